@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/order"
+	"bybit-bot/internal/rest"
+	"bybit-bot/internal/risk"
+	"bybit-bot/internal/store"
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/websocket"
+)
+
+// scan prints the candidates of the next funding time and how each of them
+// would be entered right now, without placing anything.
+func scan(cfg *config.Config, restClient *rest.RestClient) {
+	equity, err := restClient.GetBalance()
+	if err != nil {
+		mlog.Fatalf("failed to get balance: %v", err)
+	}
+	candidates, fundingTime := restClient.GetTop5Exchanges()
+
+	fmt.Printf("next funding time: %s (in %s)\n", fundingTime.Format(time.RFC3339), time.Until(fundingTime).Truncate(time.Second))
+	fmt.Printf("equity: %f USDT\n\n", equity)
+	if len(candidates) == 0 {
+		fmt.Println("no candidate above min_funding_rate_percent")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "symbol\tfunding rate\tmark price\tside\tleverage\tquantity\tlegs\tnote")
+	for _, candidate := range candidates {
+		rate := fmt.Sprintf("%.4f%%", candidate.PremiumIndex.LastFundingRate*100)
+		plan, err := planEntry(restClient, cfg, candidate, equity)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t%s\t%v\t\t\t\t\tskipped: %v\n", candidate.Symbol.Symbol, rate, candidate.PremiumIndex.MarkPrice, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%dx\t%v\t%d\t\n", candidate.Symbol.Symbol, rate, plan.price, plan.side, plan.leverage, plan.quantity, len(plan.legs))
+	}
+	w.Flush()
+}
+
+func printBalance(restClient *rest.RestClient) {
+	balance, err := restClient.GetBalance()
+	if err != nil {
+		mlog.Fatalf("failed to get balance: %v", err)
+	}
+	fmt.Printf("%f USDT\n", balance)
+}
+
+func printPositions(restClient *rest.RestClient, args []string) {
+	flags := flag.NewFlagSet("positions", flag.ExitOnError)
+	symbol := flags.String("symbol", "", "only the position on symbol")
+	flags.Parse(args)
+
+	positions, err := restClient.GetPositions(*symbol)
+	if err != nil {
+		mlog.Fatalf("failed to get positions: %v", err)
+	}
+	if len(positions) == 0 {
+		fmt.Println("no open positions")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "symbol\tside\tsize\tentry price\topened")
+	for _, p := range positions {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\n", p.Symbol, p.Side, p.Size, p.EntryPrice, formatMillis(p.CreatedTime))
+	}
+	w.Flush()
+}
+
+func printOrders(restClient *rest.RestClient, args []string) {
+	flags := flag.NewFlagSet("orders", flag.ExitOnError)
+	symbol := flags.String("symbol", "", "only the orders on symbol")
+	flags.Parse(args)
+
+	orders, err := restClient.GetOpenOrders(*symbol)
+	if err != nil {
+		mlog.Fatalf("failed to get open orders: %v", err)
+	}
+	if len(orders) == 0 {
+		fmt.Println("no open orders")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "symbol\tside\ttype\tqty\tfilled\tprice\ttrigger\treduce only\tstatus\torder link id\tcreated")
+	for _, o := range orders {
+		orderType := o.OrderType
+		if o.StopOrderType != "" {
+			orderType += " " + o.StopOrderType
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%v\t%s\t%s\t%t\t%s\t%s\t%s\n",
+			o.Symbol, o.Side, orderType, o.Qty, o.CumExecQty, o.Price, o.TriggerPrice, o.ReduceOnly, o.OrderStatus, o.OrderLinkId, formatMillis(o.CreatedTime))
+	}
+	w.Flush()
+}
+
+// closeAll cancels every open order and closes every position with a
+// reduce-only market order, through the trade websocket with REST as fallback.
+// Orders go first so no exit of the bot fires into a position being closed.
+func closeAll(cfg *config.Config, restClient *rest.RestClient, args []string) {
+	flags := flag.NewFlagSet("close-all", flag.ExitOnError)
+	symbol := flags.String("symbol", "", "only the orders and position on symbol")
+	flags.Parse(args)
+
+	orders := newRouter(cfg, restClient)
+
+	open, err := restClient.GetOpenOrders(*symbol)
+	if err != nil {
+		mlog.Fatalf("failed to get open orders: %v", err)
+	}
+	for _, o := range open {
+		if _, err := orders.Cancel(o.Symbol, order.Result{OrderId: o.OrderId, OrderLinkId: o.OrderLinkId}); err != nil {
+			mlog.Printf("failed to cancel order %s of %s: %v", o.OrderId, o.Symbol, err)
+			continue
+		}
+		mlog.Printf("cancelled order %s of %s", o.OrderId, o.Symbol)
+	}
+
+	positions, err := restClient.GetPositions(*symbol)
+	if err != nil {
+		mlog.Fatalf("failed to get positions: %v", err)
+	}
+	for _, p := range positions {
+		side := types.TradeSellSide
+		if p.Side == string(types.TradeSellSide) {
+			side = types.TradeBuySide
+		}
+		if _, err := orders.CloseOrder(p.Symbol, side, p.Size, 0); err != nil {
+			mlog.Printf("failed to close %s %s %v: %v", p.Symbol, p.Side, p.Size, err)
+			continue
+		}
+		mlog.Printf("closed %s %s %v", p.Symbol, p.Side, p.Size)
+	}
+}
+
+// setLeverage sets the leverage of a symbol, leverage from the config unless
+// given.
+func setLeverage(cfg *config.Config, restClient *rest.RestClient, args []string) {
+	flags := flag.NewFlagSet("set-leverage", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: set-leverage SYMBOL [LEVERAGE]")
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	symbol, leverage := flags.Arg(0), cfg.Leverage
+	if flags.NArg() == 2 {
+		var err error
+		if leverage, err = strconv.Atoi(flags.Arg(1)); err != nil || leverage <= 0 {
+			mlog.Fatalf("leverage should be a positive integer: %s", flags.Arg(1))
+		}
+	}
+	if err := restClient.SetLeverage(leverage, symbol); err != nil {
+		mlog.Fatalf("%v", err)
+	}
+	mlog.Printf("set leverage of %s to %dx", symbol, leverage)
+}
+
+// kill fires the kill switch of the bot running on this config by creating the
+// kill switch file, and waits for the bot to remove it.
+func kill(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("kill", flag.ExitOnError)
+	wait := flags.Int("wait", 60, "seconds to wait for the bot to handle the kill switch")
+	flags.Parse(args)
+
+	if err := os.WriteFile(cfg.KillSwitchFile, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		mlog.Fatalf("failed to create kill switch file: %v", err)
+	}
+	mlog.Printf("created %s, waiting for the bot", cfg.KillSwitchFile)
+
+	deadline := time.Now().Add(time.Duration(*wait) * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(cfg.KillSwitchFile); os.IsNotExist(err) {
+			mlog.Println("kill switch handled, see the bot log for the result")
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	// a bot started later shouldn't flatten on a stale request
+	os.Remove(cfg.KillSwitchFile)
+	mlog.Fatalf("no running bot handled the kill switch within %ds, use close-all to flatten without it", *wait)
+}
+
+// resetRisk clears a halt or pause of the circuit breaker and restarts the
+// drawdown from the next equity read. With no bot running the state file is
+// reset directly. A running bot holds the state file and keeps the risk state
+// in memory, so it is asked to reset through the reset file instead.
+func resetRisk(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("reset-risk", flag.ExitOnError)
+	wait := flags.Int("wait", 10, "seconds to wait for a running bot to handle the reset")
+	flags.Parse(args)
+
+	st, err := store.Open(cfg.StateFile)
+	if err == nil {
+		defer st.Close()
+		risk.NewManager(cfg, st).Reset()
+		mlog.Println("no running bot, risk state reset in the state file")
+		return
+	}
+	if !errors.Is(err, store.ErrLocked) {
+		mlog.Fatalf("failed to open state file: %v", err)
+	}
+
+	path := resetRiskFile(cfg)
+	if err := os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		mlog.Fatalf("failed to create reset file: %v", err)
+	}
+	mlog.Printf("a bot is running, created %s and waiting for it", path)
+
+	deadline := time.Now().Add(time.Duration(*wait) * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			mlog.Println("risk state reset by the running bot")
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	// a bot started later shouldn't reset on a stale request
+	os.Remove(path)
+	mlog.Fatalf("the running bot didn't handle the reset within %ds, see its log", *wait)
+}
+
+// newRouter connects the trade websocket and routes orders through it, REST
+// taking over while it is down. The websocket is given a moment to
+// authenticate, a command run once shouldn't go to REST for the handshake.
+func newRouter(cfg *config.Config, restClient *rest.RestClient) *order.Router {
+	tradeClient := websocket.NewTradeClient(cfg)
+	for i := 0; i < 30 && !tradeClient.Available(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return order.NewRouter(cfg, tradeClient, restClient)
+}
+
+func formatMillis(ms string) string {
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil || millis <= 0 {
+		return ""
+	}
+	return time.UnixMilli(millis).Format(time.RFC3339)
+}
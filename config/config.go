@@ -3,29 +3,72 @@ package config
 import (
 	"bybit-bot/internal/types"
 	"encoding/json"
+	"io"
 	"log"
+	"math"
 	"os"
 )
 
 var clog = log.New(os.Stdout, "[CONFIG] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
 
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	clog.SetOutput(w)
+}
+
 type Config struct {
-	ApiKey                 string           `json:"api_key"`
-	HMACSecret             string           `json:"hmac_secret"`
-	TestMode               bool             `json:"test_mode"`
-	TestApiKey             string           `json:"test_api_key"`
-	TestHMACSecret         string           `json:"test_hmac_secret"`
-	Margin                 float64          `json:"margin"`
-	MinFundingRate         float64          `json:"min_funding_rate_percent"`
-	StopRatio              float64          `json:"stop_percent"`
-	TakeProfitRatio        float64          `json:"take_profit_percent"`
-	FirstOrderTimeOffset   int64            `json:"first_order_time_offset_ms"`
-	MarginType             types.MarginType `json:"margin_type"`
-	Leverage               int              `json:"leverage"`
-	BreakevenEnabled       bool             `json:"breakeven_enabled"`
-	BreakevenPercent       float64          `json:"breakeven_percent"`
-	BreakevenWindowSize    int              `json:"breakeven_window_size"`
-	BreakevenPlaceDuration int              `json:"breakeven_place_duration"`
+	ApiKey                 string                 `json:"api_key"`
+	HMACSecret             string                 `json:"hmac_secret"`
+	TestMode               bool                   `json:"test_mode"`
+	TestApiKey             string                 `json:"test_api_key"`
+	TestHMACSecret         string                 `json:"test_hmac_secret"`
+	Margin                 float64                `json:"margin"`
+	SizingMode             types.SizingMode       `json:"sizing_mode"`
+	EquityRatio            float64                `json:"equity_percent"`
+	RiskRatio              float64                `json:"risk_percent"`
+	MinFundingRate         float64                `json:"min_funding_rate_percent"`
+	StopRatio              float64                `json:"stop_percent"`
+	TakeProfitRatio        float64                `json:"take_profit_percent"`
+	FirstOrderTimeOffset   int64                  `json:"first_order_time_offset_ms"`
+	MarginType             types.MarginType       `json:"margin_type"`
+	Leverage               int                    `json:"leverage"`
+	LeverageLimitAction    types.LeverageAction   `json:"leverage_limit_action"`
+	BreakevenEnabled       bool                   `json:"breakeven_enabled"`
+	BreakevenPercent       float64                `json:"breakeven_percent"`
+	BreakevenWindowSize    int                    `json:"breakeven_window_size"`
+	BreakevenPlaceDuration int                    `json:"breakeven_place_duration"`
+	TPSLMode               types.TPSLMode         `json:"tpsl_mode"`
+	TrailingEnabled        bool                   `json:"trailing_enabled"`
+	TrailingMode           types.TrailingMode     `json:"trailing_mode"`
+	TrailingActivation     float64                `json:"trailing_activation_percent"`
+	TrailingDistance       float64                `json:"trailing_distance_percent"`
+	TrailingStep           float64                `json:"trailing_step_percent"`
+	TrailingPriceSource    types.PriceSource      `json:"trailing_price_source"`
+	TakeProfitLadder       []types.TakeProfitStep `json:"take_profit_ladder"`
+	MaxHoldDuration        int                    `json:"max_hold_duration"`
+	ForcedExitOrderType    types.ExitOrderType    `json:"forced_exit_order_type"`
+	ForcedExitSlippage     float64                `json:"forced_exit_slippage_percent"`
+	StopOrderType          types.StopOrderType    `json:"stop_order_type"`
+	StopLimitSlippage      float64                `json:"stop_limit_slippage_percent"`
+	StopTriggerBy          types.PriceSource      `json:"stop_trigger_by"`
+	StopCloseOnTrigger     bool                   `json:"stop_close_on_trigger"`
+	EntryOrderType         types.EntryOrderType   `json:"entry_order_type"`
+	EntryMaxSlippage       float64                `json:"entry_max_slippage_percent"`
+	DepthCheckEnabled      bool                   `json:"depth_check_enabled"`
+	DepthLimit             int                    `json:"depth_limit"`
+	DepthMinQuantityRatio  float64                `json:"depth_min_quantity_percent"`
+	FeeAware               bool                   `json:"fee_aware"`
+	EVFilterEnabled        bool                   `json:"ev_filter_enabled"`
+	MinExpectedValue       float64                `json:"min_expected_value_percent"`
+	MaxDailyLoss           float64                `json:"max_daily_loss_percent"`
+	MaxDrawdown            float64                `json:"max_drawdown_percent"`
+	StateFile              string                 `json:"state_file"`
+	RiskStateFile          string                 `json:"risk_state_file"`
+	FundingRateGap         float64                `json:"funding_rate_gap_percent"`
+	ReconcilePolicy        types.ReconcilePolicy  `json:"reconcile_policy"`
+	ReconcileInterval      int                    `json:"reconcile_interval"`
+	KillSwitchFile         string                 `json:"kill_switch_file"`
+	ShutdownPolicy         types.ShutdownPolicy   `json:"shutdown_policy"`
 }
 
 func NewConfig(configPath string) *Config {
@@ -48,6 +91,50 @@ func NewConfig(configPath string) *Config {
 	config.MinFundingRate = config.MinFundingRate / 100
 	config.StopRatio = config.StopRatio / 100
 	config.TakeProfitRatio = config.TakeProfitRatio / 100
+	config.TrailingActivation = config.TrailingActivation / 100
+	config.TrailingDistance = config.TrailingDistance / 100
+	config.TrailingStep = config.TrailingStep / 100
+	config.ForcedExitSlippage = config.ForcedExitSlippage / 100
+	config.StopLimitSlippage = config.StopLimitSlippage / 100
+	config.EntryMaxSlippage = config.EntryMaxSlippage / 100
+	config.DepthMinQuantityRatio = config.DepthMinQuantityRatio / 100
+	config.MinExpectedValue = config.MinExpectedValue / 100
+	config.EquityRatio = config.EquityRatio / 100
+	config.RiskRatio = config.RiskRatio / 100
+	config.MaxDailyLoss = config.MaxDailyLoss / 100
+	config.MaxDrawdown = config.MaxDrawdown / 100
+	config.FundingRateGap = config.FundingRateGap / 100
+
+	if config.StateFile == "" {
+		config.StateFile = "state.jsonl"
+	}
+
+	// only read to import the risk state of versions before state_file
+	if config.RiskStateFile == "" {
+		config.RiskStateFile = "risk_state.json"
+	}
+
+	if config.KillSwitchFile == "" {
+		config.KillSwitchFile = "kill_switch"
+	}
+
+	if len(config.TakeProfitLadder) == 0 {
+		config.TakeProfitLadder = []types.TakeProfitStep{{Ratio: config.TakeProfitRatio, QtyRatio: 1}}
+	} else {
+		total := 0.0
+		for i := range config.TakeProfitLadder {
+			step := &config.TakeProfitLadder[i]
+			if step.Ratio <= 0 || step.QtyRatio <= 0 {
+				clog.Fatalf("take_profit_ladder: percent and qty_percent should be positive")
+			}
+			step.Ratio = step.Ratio / 100
+			step.QtyRatio = step.QtyRatio / 100
+			total += step.QtyRatio
+		}
+		if math.Abs(total-1) > 1e-9 {
+			clog.Fatalf("take_profit_ladder: qty_percent should add up to 100")
+		}
+	}
 
 	if config.MarginType == "" {
 		clog.Fatalf("margin_type is required")
@@ -57,13 +144,127 @@ func NewConfig(configPath string) *Config {
 		clog.Fatalf("leverage is required")
 	}
 
-	if config.Margin == 0 {
-		clog.Fatalf("margin is required")
+	if config.SizingMode == "" {
+		config.SizingMode = types.SizingModeFixed
+	}
+
+	switch config.SizingMode {
+	case types.SizingModeFixed:
+		if config.Margin == 0 {
+			clog.Fatalf("margin is required")
+		}
+	case types.SizingModeEquityPercent:
+		if config.EquityRatio <= 0 || config.EquityRatio > 1 {
+			clog.Fatalf("equity_percent should be between 0 and 100 with sizing_mode EQUITY_PERCENT")
+		}
+	case types.SizingModeRisk:
+		if config.RiskRatio <= 0 || config.RiskRatio > 1 {
+			clog.Fatalf("risk_percent should be between 0 and 100 with sizing_mode RISK")
+		}
+		if config.StopRatio <= 0 {
+			clog.Fatalf("stop_percent is required with sizing_mode RISK")
+		}
+	default:
+		clog.Fatalf("sizing_mode should only be one of FIXED, EQUITY_PERCENT or RISK (case sensitive)")
 	}
 
 	if config.MarginType != types.MarginTypeIsolated && config.MarginType != types.MarginTypeRegular && config.MarginType != types.MarginTypePortfolio {
 		clog.Fatalf("margin_type should only be one of ISOLATED, REGULAR or PORTFOLIO (case sensitive)")
 	}
 
+	if config.LeverageLimitAction == "" {
+		config.LeverageLimitAction = types.LeverageActionClamp
+	}
+
+	if config.LeverageLimitAction != types.LeverageActionClamp && config.LeverageLimitAction != types.LeverageActionSkip {
+		clog.Fatalf("leverage_limit_action should only be one of CLAMP or SKIP (case sensitive)")
+	}
+
+	if config.ReconcilePolicy == "" {
+		config.ReconcilePolicy = types.ReconcilePolicyAlert
+	}
+
+	if config.ReconcilePolicy != types.ReconcilePolicyAdopt && config.ReconcilePolicy != types.ReconcilePolicyClose && config.ReconcilePolicy != types.ReconcilePolicyAlert {
+		clog.Fatalf("reconcile_policy should only be one of ADOPT, CLOSE or ALERT (case sensitive)")
+	}
+
+	if config.ShutdownPolicy == "" {
+		config.ShutdownPolicy = types.ShutdownPolicyLeave
+	}
+
+	if config.ShutdownPolicy != types.ShutdownPolicyLeave && config.ShutdownPolicy != types.ShutdownPolicyFlatten {
+		clog.Fatalf("shutdown_policy should only be one of LEAVE or FLATTEN (case sensitive)")
+	}
+
+	if config.TPSLMode == "" {
+		config.TPSLMode = types.TPSLModeFill
+	}
+
+	if config.TPSLMode != types.TPSLModeFill && config.TPSLMode != types.TPSLModeOrder && config.TPSLMode != types.TPSLModeTradingStop {
+		clog.Fatalf("tpsl_mode should only be one of FILL, ORDER or TRADING_STOP (case sensitive)")
+	}
+
+	if config.TrailingMode == "" {
+		config.TrailingMode = types.TrailingModeClient
+	}
+
+	if config.TrailingPriceSource == "" {
+		config.TrailingPriceSource = types.PriceSourceMark
+	}
+
+	if config.TrailingEnabled {
+		if config.TrailingMode != types.TrailingModeNative && config.TrailingMode != types.TrailingModeClient {
+			clog.Fatalf("trailing_mode should only be one of NATIVE or CLIENT (case sensitive)")
+		}
+
+		if config.TrailingPriceSource != types.PriceSourceMark && config.TrailingPriceSource != types.PriceSourceLast {
+			clog.Fatalf("trailing_price_source should only be one of MARK or LAST (case sensitive)")
+		}
+
+		if config.TrailingDistance <= 0 {
+			clog.Fatalf("trailing_distance_percent is required when trailing_enabled is true")
+		}
+	}
+
+	if config.ForcedExitOrderType == "" {
+		config.ForcedExitOrderType = types.ExitOrderTypeMarket
+	}
+
+	if config.ForcedExitOrderType != types.ExitOrderTypeMarket && config.ForcedExitOrderType != types.ExitOrderTypeIOCLimit {
+		clog.Fatalf("forced_exit_order_type should only be one of MARKET or IOC_LIMIT (case sensitive)")
+	}
+
+	if config.StopOrderType == "" {
+		config.StopOrderType = types.StopOrderTypeMarket
+	}
+
+	if config.StopOrderType != types.StopOrderTypeMarket && config.StopOrderType != types.StopOrderTypeLimit {
+		clog.Fatalf("stop_order_type should only be one of MARKET or LIMIT (case sensitive)")
+	}
+
+	if config.StopTriggerBy == "" {
+		config.StopTriggerBy = types.PriceSourceMark
+	}
+
+	if config.StopTriggerBy != types.PriceSourceMark && config.StopTriggerBy != types.PriceSourceLast && config.StopTriggerBy != types.PriceSourceIndex {
+		clog.Fatalf("stop_trigger_by should only be one of MARK, LAST or INDEX (case sensitive)")
+	}
+
+	if config.EntryOrderType == "" {
+		config.EntryOrderType = types.EntryOrderTypeMarket
+	}
+
+	if config.EntryOrderType != types.EntryOrderTypeMarket && config.EntryOrderType != types.EntryOrderTypeIOCLimit {
+		clog.Fatalf("entry_order_type should only be one of MARKET or IOC_LIMIT (case sensitive)")
+	}
+
+	if config.EntryOrderType == types.EntryOrderTypeIOCLimit && config.EntryMaxSlippage <= 0 {
+		clog.Fatalf("entry_max_slippage_percent is required when entry_order_type is IOC_LIMIT")
+	}
+
+	if config.DepthLimit == 0 {
+		config.DepthLimit = 200
+	}
+
 	return &config
 }
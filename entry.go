@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/market"
+	"bybit-bot/internal/order"
+	"bybit-bot/internal/position"
+	"bybit-bot/internal/rest"
+	"bybit-bot/internal/store"
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+// entryPlan is the entry order the bot is going to place at funding time.
+type entryPlan struct {
+	top      types.ExchangeInfo
+	side     types.TradeSide
+	stopSide types.TradeSide
+	price    float64
+	quantity float64
+	// legs splits quantity into orders the exchange accepts
+	legs     []float64
+	leverage int
+	fees     types.FeeRate
+}
+
+// selectEntry plans the entry of the first candidate that passes every
+// pre-entry check, sized against equity, or returns nil if none does.
+func selectEntry(restClient *rest.RestClient, cfg *config.Config, candidates []types.ExchangeInfo, equity float64) *entryPlan {
+	for _, candidate := range candidates {
+		plan, err := planEntry(restClient, cfg, candidate, equity)
+		if err != nil {
+			mlog.Printf("skipping %s: %v", candidate.Symbol.Symbol, err)
+			continue
+		}
+		if err := restClient.SetLeverage(plan.leverage, candidate.Symbol.Symbol); err != nil {
+			mlog.Printf("skipping %s: %v", candidate.Symbol.Symbol, err)
+			continue
+		}
+		mlog.Printf("set leverage to %dx", plan.leverage)
+		return plan
+	}
+	return nil
+}
+
+func planEntry(restClient *rest.RestClient, cfg *config.Config, top types.ExchangeInfo, equity float64) (*entryPlan, error) {
+	open, err := restClient.GetPositions(top.Symbol.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("a position is already open on %s", top.Symbol.Symbol)
+	}
+
+	mlog.Printf("querying latest price for %s", top.Symbol.Symbol)
+	premiumIndex, err := restClient.GetPremiumIndex(top.Symbol.Symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get premium index: %v", err)
+	}
+	priceFloat := premiumIndex.MarkPrice
+	mlog.Printf("latest price: %f", priceFloat)
+
+	leverage, maxNotional, err := checkLeverage(restClient, cfg, top)
+	if err != nil {
+		return nil, err
+	}
+
+	var fees types.FeeRate
+	if cfg.FeeAware || cfg.EVFilterEnabled {
+		fees = feeRate(restClient, top.Symbol.Symbol)
+	}
+
+	notional, err := entryNotional(cfg, equity, leverage, fees)
+	if err != nil {
+		return nil, err
+	}
+	if maxNotional > 0 && notional > maxNotional {
+		mlog.Printf("notional %f of %s exceeds the risk limit of %f at %dx, capping", notional, top.Symbol.Symbol, maxNotional, leverage)
+		notional = maxNotional
+	}
+	quantity := notional / priceFloat
+
+	mlog.Printf("quantity: %v", quantity)
+
+	if quantity < top.Symbol.MinQty {
+		return nil, fmt.Errorf("calculated order quantity is %v, smaller than min qty %v", quantity, top.Symbol.MinQty)
+	}
+
+	qtyStep := top.Symbol.QtyStep
+	if qtyStep == 0 {
+		qtyStep = top.Symbol.MinQty
+	}
+	quantity = utils.Truncate(quantity, qtyStep)
+
+	side := types.TradeSellSide
+	stopSide := types.TradeBuySide
+
+	if top.PremiumIndex.LastFundingRate > 0 {
+		side = types.TradeBuySide
+		stopSide = types.TradeSellSide
+	}
+
+	var slippage float64
+	if cfg.DepthCheckEnabled {
+		if quantity, slippage, err = checkDepth(restClient, cfg, top, side, quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	if notional := quantity * priceFloat; notional < top.Symbol.MinNotional {
+		return nil, fmt.Errorf("order value %f is below the min notional value %f", notional, top.Symbol.MinNotional)
+	}
+
+	legs := splitEntry(cfg, top, quantity, priceFloat)
+	if len(legs) > 1 {
+		mlog.Printf("quantity %v of %s exceeds the max order qty, splitting into %v", quantity, top.Symbol.Symbol, legs)
+		quantity = 0
+		for _, leg := range legs {
+			quantity += leg
+		}
+		quantity = utils.RoundStep(quantity, top.Symbol.QtyStep)
+	}
+
+	if cfg.FeeAware || cfg.EVFilterEnabled {
+		ev := market.ExpectedValue(top.PremiumIndex.LastFundingRate, fees, slippage)
+		mlog.Printf("expected value of %s: %.4f%% (funding %.4f%%, taker fee %.4f%%, slippage %.4f%%)",
+			top.Symbol.Symbol, ev*100, top.PremiumIndex.LastFundingRate*100, fees.Taker*100, slippage*100)
+		if cfg.EVFilterEnabled && ev < cfg.MinExpectedValue {
+			return nil, fmt.Errorf("expected value %.4f%% is below %.4f%%", ev*100, cfg.MinExpectedValue*100)
+		}
+	}
+
+	return &entryPlan{
+		top:      top,
+		side:     side,
+		stopSide: stopSide,
+		price:    priceFloat,
+		quantity: quantity,
+		legs:     legs,
+		leverage: leverage,
+		fees:     fees,
+	}, nil
+}
+
+// entryNotional returns the position value to open with the configured
+// sizing mode. With RISK the position is sized so that a stop out, fees
+// included with fee_aware, loses risk_percent of equity.
+func entryNotional(cfg *config.Config, equity float64, leverage int, fees types.FeeRate) (float64, error) {
+	var margin float64
+	switch cfg.SizingMode {
+	case types.SizingModeEquityPercent:
+		margin = equity * cfg.EquityRatio
+	case types.SizingModeRisk:
+		notional := equity * cfg.RiskRatio / (cfg.StopRatio + position.StopFee(cfg, fees))
+		margin = notional / float64(leverage)
+	default:
+		margin = cfg.Margin
+	}
+
+	if margin > equity {
+		if cfg.SizingMode == types.SizingModeFixed {
+			return 0, fmt.Errorf("margin %f exceeds equity %f", margin, equity)
+		}
+		mlog.Printf("margin %f exceeds equity %f, capping", margin, equity)
+		margin = equity
+	}
+	mlog.Printf("sizing mode %s: equity %f, margin %f, notional %f", cfg.SizingMode, equity, margin, margin*float64(leverage))
+	return margin * float64(leverage), nil
+}
+
+// checkLeverage clamps the configured leverage to the max leverage of the
+// symbol, or rejects the symbol with leverage_limit_action SKIP. It returns the
+// leverage to use and the largest notional the risk limit tiers allow at it,
+// zero if the tiers are unknown.
+func checkLeverage(restClient *rest.RestClient, cfg *config.Config, top types.ExchangeInfo) (int, float64, error) {
+	leverage := cfg.Leverage
+	maxLeverage := top.Symbol.MaxLeverage
+	if maxLeverage > 0 && float64(leverage) > maxLeverage {
+		if cfg.LeverageLimitAction == types.LeverageActionSkip {
+			return 0, 0, fmt.Errorf("leverage %dx exceeds max leverage %vx", leverage, maxLeverage)
+		}
+		leverage = int(maxLeverage)
+		if leverage < 1 {
+			return 0, 0, fmt.Errorf("max leverage %vx is below 1x", maxLeverage)
+		}
+		mlog.Printf("max leverage of %s is %vx, clamping leverage from %dx to %dx", top.Symbol.Symbol, maxLeverage, cfg.Leverage, leverage)
+	}
+
+	tiers, err := restClient.GetRiskLimits(top.Symbol.Symbol)
+	if err != nil {
+		mlog.Printf("Warning: %v, notional is not capped to the risk limit", err)
+		return leverage, 0, nil
+	}
+	maxNotional := market.MaxNotional(tiers, float64(leverage))
+	if maxNotional == 0 && len(tiers) > 0 {
+		return 0, 0, fmt.Errorf("no risk limit tier allows %dx", leverage)
+	}
+	mlog.Printf("leverage of %s: %dx, risk limit allows up to %f notional", top.Symbol.Symbol, leverage, maxNotional)
+	return leverage, maxNotional, nil
+}
+
+// maxEntryLegs bounds how many orders one entry is split into, all legs go out
+// in a single batch request.
+const maxEntryLegs = 10
+
+// splitEntry splits quantity into legs no larger than what the symbol accepts
+// for the entry order type. The min notional applies to every order, a
+// remainder leg worth less than it at price is dropped like one below the min
+// qty.
+func splitEntry(cfg *config.Config, top types.ExchangeInfo, quantity, price float64) []float64 {
+	maxQty := top.Symbol.MaxQty
+	if cfg.EntryOrderType == types.EntryOrderTypeMarket && top.Symbol.MaxMktQty > 0 {
+		maxQty = top.Symbol.MaxMktQty
+	}
+	if maxQty > 0 && quantity > maxQty*maxEntryLegs {
+		mlog.Printf("quantity %v of %s needs more than %d orders, capping to %v", quantity, top.Symbol.Symbol, maxEntryLegs, maxQty*maxEntryLegs)
+		quantity = maxQty * maxEntryLegs
+	}
+	minQty := top.Symbol.MinQty
+	if price > 0 {
+		minQty = math.Max(minQty, top.Symbol.MinNotional/price)
+	}
+	return utils.Split(quantity, maxQty, minQty, top.Symbol.QtyStep)
+}
+
+// placeEntry sends the entry orders, in one batch if the entry is split, and
+// tells positions about the legs that never made it to the book. It reports
+// whether any leg was placed.
+func placeEntry(orders *order.Router, positions *position.Manager, requests []map[string]string) bool {
+	if len(requests) == 1 {
+		if _, err := orders.CreateOrder(requests[0]); err != nil {
+			mlog.Printf("failed to place order: %v", err)
+			positions.CancelEntry(requests[0]["orderLinkId"])
+			return false
+		}
+		return true
+	}
+
+	results, err := orders.CreateBatch(requests)
+	if err != nil {
+		mlog.Printf("failed to place orders: %v", err)
+		failed := make([]string, 0, len(requests))
+		for _, params := range requests {
+			failed = append(failed, params["orderLinkId"])
+		}
+		positions.CancelEntry(failed...)
+		return false
+	}
+	var failed []string
+	for i, res := range results {
+		if res.Err != nil {
+			mlog.Printf("failed to place order %d of %d: %v", i+1, len(requests), res.Err)
+			failed = append(failed, requests[i]["orderLinkId"])
+		}
+	}
+	if len(failed) > 0 {
+		positions.CancelEntry(failed...)
+	}
+	return len(failed) < len(requests)
+}
+
+// fundingEvent records that the entry of a funding time was sent, so a
+// restart doesn't trade the same funding time twice.
+type fundingEvent struct {
+	Symbol       string   `json:"symbol"`
+	OrderLinkIds []string `json:"order_link_ids"`
+}
+
+func fundingKey(fundingTime time.Time) string {
+	return strconv.FormatInt(fundingTime.UnixMilli(), 10)
+}
+
+// traded reports whether an entry was already sent for fundingTime.
+func traded(st *store.Store, fundingTime time.Time) bool {
+	var event fundingEvent
+	ok, err := st.Get(store.KindFunding, fundingKey(fundingTime), &event)
+	if err != nil {
+		mlog.Printf("Warning: %v", err)
+	}
+	return ok
+}
+
+// markTraded records the entry of fundingTime before it is sent.
+func markTraded(st *store.Store, fundingTime time.Time, symbol string, orderLinkIds []string) {
+	event := fundingEvent{Symbol: symbol, OrderLinkIds: orderLinkIds}
+	if err := st.Put(store.KindFunding, fundingKey(fundingTime), event); err != nil {
+		mlog.Printf("Warning: failed to record funding time %s: %v", fundingTime, err)
+	}
+}
+
+// defaultFeeRate is Bybit's base tier for perpetuals, used when the account's
+// own rates can't be read.
+var defaultFeeRate = types.FeeRate{Taker: 0.00055, Maker: 0.0002}
+
+func feeRate(restClient *rest.RestClient, symbol string) types.FeeRate {
+	fees, err := restClient.GetFeeRate(symbol)
+	if err != nil {
+		mlog.Printf("Warning: %v, assuming taker %f and maker %f", err, defaultFeeRate.Taker, defaultFeeRate.Maker)
+		return defaultFeeRate
+	}
+	return fees
+}
+
+// checkDepth estimates the VWAP impact of quantity on the current order book
+// and shrinks it until the expected slippage stays within the funding edge.
+// It returns the quantity to trade and its expected slippage.
+func checkDepth(restClient *rest.RestClient, cfg *config.Config, top types.ExchangeInfo, side types.TradeSide, quantity float64) (float64, float64, error) {
+	book, err := restClient.GetOrderbook(top.Symbol.Symbol, cfg.DepthLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	edge := math.Abs(top.PremiumIndex.LastFundingRate)
+	qtyStep := top.Symbol.QtyStep
+	if qtyStep == 0 {
+		qtyStep = top.Symbol.MinQty
+	}
+	minQuantity := math.Max(top.Symbol.MinQty, quantity*cfg.DepthMinQuantityRatio)
+
+	fitted, impact := market.FitQuantity(book, side, quantity, minQuantity, qtyStep, edge)
+	if fitted == 0 {
+		if _, impact, ok := market.Impact(book, side, minQuantity); ok {
+			return 0, 0, fmt.Errorf("expected slippage of %.4f%% at the min quantity %v exceeds funding edge %.4f%%", impact*100, minQuantity, edge*100)
+		}
+		return 0, 0, fmt.Errorf("order book of %d levels is too thin to fill the min quantity %v", cfg.DepthLimit, minQuantity)
+	}
+	if fitted < quantity {
+		mlog.Printf("order book of %s too thin for %v, shrinking to %v (impact %.4f%%, edge %.4f%%)", top.Symbol.Symbol, quantity, fitted, impact*100, edge*100)
+	} else {
+		mlog.Printf("order book impact of %v %s: %.4f%%, edge %.4f%%", quantity, top.Symbol.Symbol, impact*100, edge*100)
+	}
+	return fitted, impact, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"bybit-bot/internal/journal"
+)
+
+// exportJournal writes the trade journal to a file as CSV or JSON lines.
+func exportJournal(trades *journal.Journal, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "csv", "export format, csv or jsonl")
+	output := flags.String("o", "", "output file, trades.<format> by default")
+	flags.Parse(args)
+
+	if *format != "csv" && *format != "jsonl" {
+		mlog.Fatalf("format should only be one of csv or jsonl")
+	}
+	if *output == "" {
+		*output = "trades." + *format
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		mlog.Fatalf("failed to create %s: %v", *output, err)
+	}
+	defer file.Close()
+
+	list := trades.Trades()
+	if *format == "csv" {
+		err = journal.ExportCSV(file, list)
+	} else {
+		err = journal.ExportJSONL(file, list)
+	}
+	if err != nil {
+		mlog.Fatalf("failed to export trades: %v", err)
+	}
+	mlog.Printf("exported %d trades to %s", len(list), *output)
+}
@@ -3,8 +3,10 @@ package constant
 const (
 	WS_TRADE_URL       = "wss://stream.bybit.com/v5/trade"
 	WS_STREAM_URL      = "wss://stream.bybit.com/v5/private"
+	WS_PUBLIC_URL      = "wss://stream.bybit.com/v5/public/linear"
 	REST_BASE_URL      = "https://api.bybit.com"
 	TEST_WS_TRADE_URL  = "wss://stream-testnet.bybit.com/v5/trade"
 	TEST_WS_STREAM_URL = "wss://stream-testnet.bybit.com/v5/private"
+	TEST_WS_PUBLIC_URL = "wss://stream-testnet.bybit.com/v5/public/linear"
 	TEST_REST_BASE_URL = "https://api-testnet.bybit.com"
 )
@@ -0,0 +1,137 @@
+package journal
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"bybit-bot/internal/types"
+)
+
+// settlementDelay is how long after the funding time the settlement is
+// expected to be booked in the transaction log.
+const settlementDelay = 2 * time.Minute
+
+// ReconcileFundingEvery runs ReconcileFunding every interval until stop is
+// closed.
+func (j *Journal) ReconcileFundingEvery(interval time.Duration, maxGap float64, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		j.ReconcileFunding(maxGap)
+	}
+}
+
+// ReconcileFunding attaches the funding actually settled to every filled trade
+// whose funding time has passed and that is not reconciled yet. The funding is
+// read from the SETTLEMENT entries of the transaction log and checked against
+// the Funding executions. A trade is flagged when the settled rate differs
+// from the rate seen at selection time by more than maxGap, has the opposite
+// sign, or when a position held across the funding time settled nothing.
+func (j *Journal) ReconcileFunding(maxGap float64) {
+	for _, trade := range j.Trades() {
+		if trade.FundingReconciled || trade.Status == StatusPlanned || trade.FundingTime.IsZero() {
+			continue
+		}
+		if time.Since(trade.FundingTime) < settlementDelay {
+			continue
+		}
+
+		funding, rate, flag, err := j.funding(trade, maxGap)
+		if err != nil {
+			jlog.Printf("failed to reconcile funding of trade %s: %v", trade.Id, err)
+			continue
+		}
+		if flag != "" {
+			jlog.Printf("funding of trade %s of %s flagged: %s", trade.Id, trade.Symbol, flag)
+		} else {
+			jlog.Printf("funding of trade %s of %s: %f at rate %.4f%%", trade.Id, trade.Symbol, funding, rate*100)
+		}
+		err = j.Update(trade.Id, func(t *Trade) {
+			t.Funding = funding
+			t.FundingRateSettled = rate
+			t.FundingFlag = flag
+			t.FundingReconciled = true
+		})
+		if err != nil {
+			jlog.Printf("failed to save funding of trade %s: %v", trade.Id, err)
+		}
+	}
+}
+
+// funding returns the funding received by trade, negative if paid, the rate
+// it settled at and why the trade is flagged, if it is.
+func (j *Journal) funding(trade Trade, maxGap float64) (float64, float64, string, error) {
+	from, to := trade.FundingTime.Add(-time.Minute), trade.FundingTime.Add(settlementDelay)
+	settlements, err := j.restClient.GetSettlements(trade.Symbol, from, to)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	executions, err := j.restClient.GetExecutions(trade.Symbol, from)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	// the transaction log books the fee paid as positive
+	funding, rate := 0.0, 0.0
+	for _, s := range settlements {
+		funding -= s.Funding
+		rate = s.FeeRate
+	}
+	executed := 0.0
+	for _, e := range executions {
+		if e.ExecType == "Funding" && !time.UnixMilli(e.ExecTime).After(to) {
+			executed -= e.ExecFee
+			if rate == 0 {
+				rate = e.FeeRate
+			}
+		}
+	}
+
+	heldAcross := !entryFillTime(trade, executions).After(trade.FundingTime) &&
+		(trade.ExitTime.IsZero() || trade.ExitTime.After(trade.FundingTime))
+	switch {
+	case len(settlements) == 0 && executed == 0:
+		if heldAcross {
+			return 0, 0, "no funding settled for a position held across the funding time", nil
+		}
+		return 0, 0, "", nil
+	case len(settlements) == 0:
+		funding = executed
+	case math.Abs(funding-executed) > 1e-8:
+		return funding, rate, fmt.Sprintf("transaction log funding %f differs from executions %f", funding, executed), nil
+	}
+
+	if rate*trade.FundingRateSeen < 0 {
+		return funding, rate, fmt.Sprintf("settled rate %.4f%% has the opposite sign of %.4f%% seen", rate*100, trade.FundingRateSeen*100), nil
+	}
+	if gap := math.Abs(rate - trade.FundingRateSeen); maxGap > 0 && gap > maxGap {
+		return funding, rate, fmt.Sprintf("settled rate %.4f%% is %.4f%% away from %.4f%% seen", rate*100, gap*100, trade.FundingRateSeen*100), nil
+	}
+	return funding, rate, "", nil
+}
+
+// entryFillTime returns when the entry of trade first filled on the exchange,
+// the earliest trade execution on its side. EntryTime is when the bot learned
+// of the fill, which can be after the funding time for a fill before it. The
+// journal time is the fallback when no entry execution is in executions.
+func entryFillTime(trade Trade, executions []types.Execution) time.Time {
+	var first time.Time
+	for _, e := range executions {
+		if e.ExecType != "Trade" || e.Side != trade.Side {
+			continue
+		}
+		if t := time.UnixMilli(e.ExecTime); first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+	if first.IsZero() {
+		return trade.EntryTime
+	}
+	return first
+}
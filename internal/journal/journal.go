@@ -0,0 +1,307 @@
+package journal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"bybit-bot/internal/position"
+	"bybit-bot/internal/rest"
+	"bybit-bot/internal/store"
+	"bybit-bot/internal/types"
+)
+
+var jlog = log.New(os.Stdout, "[JOURNL] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
+
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	jlog.SetOutput(w)
+}
+
+const (
+	StatusPlanned = "planned"
+	StatusOpen    = "open"
+	StatusClosed  = "closed"
+)
+
+// Exit is one closing fill of a trade as booked by Bybit's closed PnL.
+type Exit struct {
+	Time     time.Time `json:"time"`
+	Price    float64   `json:"price"`
+	Quantity float64   `json:"quantity"`
+	Pnl      float64   `json:"pnl"`
+}
+
+// Trade is the lifecycle record of one trade, from the candidate seen before
+// the funding time to the realised PnL.
+type Trade struct {
+	Id                 string    `json:"id"`
+	Symbol             string    `json:"symbol"`
+	Side               string    `json:"side"`
+	Status             string    `json:"status"`
+	FundingTime        time.Time `json:"funding_time"`
+	FundingRateSeen    float64   `json:"funding_rate_seen"`
+	FundingRateSettled float64   `json:"funding_rate_settled"`
+	FundingReconciled  bool      `json:"funding_reconciled"`
+	FundingFlag        string    `json:"funding_flag"`
+	PlannedEntryTime   time.Time `json:"planned_entry_time"`
+	EntryTime          time.Time `json:"entry_time"`
+	ExpectedPrice      float64   `json:"expected_price"`
+	EntryPrice         float64   `json:"entry_price"`
+	PlannedQuantity    float64   `json:"planned_quantity"`
+	Quantity           float64   `json:"quantity"`
+	StopPrice          float64   `json:"stop_price"`
+	Exits              []Exit    `json:"exits"`
+	ExitPrice          float64   `json:"exit_price"`
+	ExitTime           time.Time `json:"exit_time"`
+	CloseReason        string    `json:"close_reason"`
+	Fees               float64   `json:"fees"`
+	Funding            float64   `json:"funding"`
+	RealisedPnl        float64   `json:"realised_pnl"`
+}
+
+// Journal keeps one Trade per trade in the store and fills it in as the trade
+// goes through its lifecycle.
+type Journal struct {
+	mu         sync.Mutex
+	store      *store.Store
+	restClient *rest.RestClient
+}
+
+func New(restClient *rest.RestClient, st *store.Store) *Journal {
+	return &Journal{
+		store:      st,
+		restClient: restClient,
+	}
+}
+
+// Plan records a trade whose entry is about to be sent.
+func (j *Journal) Plan(trade Trade) {
+	trade.Status = StatusPlanned
+	j.save(&trade)
+}
+
+// Opened records the entry fill of pos.
+func (j *Journal) Opened(pos *position.Position) {
+	j.update(pos, func(trade *Trade) {
+		trade.Status = StatusOpen
+		trade.EntryTime = pos.OpenedAt
+		trade.EntryPrice = pos.EntryPrice
+		trade.Quantity = pos.Quantity
+		trade.StopPrice = pos.StopPrice
+		if trade.ExpectedPrice == 0 {
+			trade.ExpectedPrice = pos.ExpectedPrice
+		}
+	})
+}
+
+// Closed waits until Bybit booked the closed PnL of pos and records the exits,
+// fees and realised PnL of the trade, which it returns.
+func (j *Journal) Closed(pos *position.Position) (Trade, error) {
+	var err error
+	for i := 0; i < 10; i++ {
+		time.Sleep(3 * time.Second)
+		var trade Trade
+		if trade, err = j.settle(pos); err == nil {
+			jlog.Printf("trade %s of %s closed: %s, realised pnl %f, fees %f", trade.Id, trade.Symbol, trade.CloseReason, trade.RealisedPnl, trade.Fees)
+			return trade, nil
+		}
+	}
+	return Trade{}, fmt.Errorf("failed to settle trade %s of %s: %v", pos.TradeId, pos.Symbol, err)
+}
+
+func (j *Journal) settle(pos *position.Position) (Trade, error) {
+	positions, err := j.restClient.GetPositions(pos.Symbol)
+	if err != nil {
+		return Trade{}, err
+	}
+	if len(positions) > 0 {
+		return Trade{}, fmt.Errorf("%s is not flat yet", pos.Symbol)
+	}
+	records, err := j.restClient.GetClosedPnl(pos.Symbol, pos.OpenedAt, time.Time{})
+	if err != nil {
+		return Trade{}, err
+	}
+	if len(records) == 0 {
+		return Trade{}, fmt.Errorf("no closed pnl of %s yet", pos.Symbol)
+	}
+	// the entry fills land just before the position is registered as open
+	executions, err := j.restClient.GetExecutions(pos.Symbol, pos.OpenedAt.Add(-time.Minute))
+	if err != nil {
+		return Trade{}, err
+	}
+
+	var settled Trade
+	j.update(pos, func(trade *Trade) {
+		trade.Status = StatusClosed
+		trade.CloseReason = pos.CloseReason
+		trade.StopPrice = pos.StopPrice
+		trade.Exits = trade.Exits[:0]
+		trade.RealisedPnl = 0
+		quantity, cost := 0.0, 0.0
+		for _, record := range records {
+			exit := Exit{
+				Time:     time.UnixMilli(record.CreatedTime),
+				Price:    record.AvgExitPrice,
+				Quantity: record.Qty,
+				Pnl:      record.ClosedPnl,
+			}
+			trade.Exits = append(trade.Exits, exit)
+			trade.RealisedPnl += record.ClosedPnl
+			quantity += exit.Quantity
+			cost += exit.Quantity * exit.Price
+			if exit.Time.After(trade.ExitTime) {
+				trade.ExitTime = exit.Time
+			}
+		}
+		sort.Slice(trade.Exits, func(a, b int) bool { return trade.Exits[a].Time.Before(trade.Exits[b].Time) })
+		if quantity > 0 {
+			trade.ExitPrice = cost / quantity
+		}
+		trade.Fees = 0
+		for _, execution := range executions {
+			if execution.ExecType == "Trade" {
+				trade.Fees += execution.ExecFee
+			}
+		}
+		settled = *trade
+	})
+	return settled, nil
+}
+
+// update applies fn to the trade of pos, creating it for positions the journal
+// has not seen planned, such as adopted ones.
+func (j *Journal) update(pos *position.Position, fn func(trade *Trade)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	trade := Trade{
+		Id:     pos.TradeId,
+		Symbol: pos.Symbol,
+		Side:   string(entrySide(pos.StopSide)),
+		Status: StatusOpen,
+	}
+	if _, err := j.store.Get(store.KindTrade, pos.TradeId, &trade); err != nil {
+		jlog.Printf("failed to load trade %s: %v", pos.TradeId, err)
+	}
+	fn(&trade)
+	if err := j.store.Put(store.KindTrade, trade.Id, trade); err != nil {
+		jlog.Printf("failed to save trade %s: %v", trade.Id, err)
+	}
+}
+
+// Update applies fn to the trade with id and saves it.
+func (j *Journal) Update(id string, fn func(trade *Trade)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var trade Trade
+	ok, err := j.store.Get(store.KindTrade, id, &trade)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unknown trade %s", id)
+	}
+	fn(&trade)
+	return j.store.Put(store.KindTrade, id, trade)
+}
+
+func (j *Journal) save(trade *Trade) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.store.Put(store.KindTrade, trade.Id, trade); err != nil {
+		jlog.Printf("failed to save trade %s: %v", trade.Id, err)
+	}
+}
+
+// Trades returns every trade in the journal, oldest first.
+func (j *Journal) Trades() []Trade {
+	var trades []Trade
+	for id, data := range j.store.List(store.KindTrade) {
+		var trade Trade
+		if err := json.Unmarshal(data, &trade); err != nil {
+			jlog.Printf("failed to decode trade %s: %v", id, err)
+			continue
+		}
+		trades = append(trades, trade)
+	}
+	sort.Slice(trades, func(a, b int) bool { return trades[a].start().Before(trades[b].start()) })
+	return trades
+}
+
+func (t Trade) start() time.Time {
+	if !t.PlannedEntryTime.IsZero() {
+		return t.PlannedEntryTime
+	}
+	return t.EntryTime
+}
+
+// ExportJSONL writes one trade per line as JSON.
+func ExportJSONL(w io.Writer, trades []Trade) error {
+	encoder := json.NewEncoder(w)
+	for _, trade := range trades {
+		if err := encoder.Encode(trade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var csvHeader = []string{
+	"id", "symbol", "side", "status",
+	"funding_time", "funding_rate_seen", "funding_rate_settled", "funding_flag",
+	"planned_entry_time", "entry_time", "expected_price", "entry_price",
+	"planned_quantity", "quantity", "stop_price",
+	"exits", "exit_price", "exit_time", "close_reason",
+	"fees", "funding", "realised_pnl",
+}
+
+// ExportCSV writes the trades as CSV with a header row. Times are RFC 3339 in
+// UTC, the exits are counted, their average is in exit_price.
+func ExportCSV(w io.Writer, trades []Trade) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, t := range trades {
+		err := writer.Write([]string{
+			t.Id, t.Symbol, t.Side, t.Status,
+			formatTime(t.FundingTime), formatFloat(t.FundingRateSeen), formatFloat(t.FundingRateSettled), t.FundingFlag,
+			formatTime(t.PlannedEntryTime), formatTime(t.EntryTime), formatFloat(t.ExpectedPrice), formatFloat(t.EntryPrice),
+			formatFloat(t.PlannedQuantity), formatFloat(t.Quantity), formatFloat(t.StopPrice),
+			strconv.Itoa(len(t.Exits)), formatFloat(t.ExitPrice), formatTime(t.ExitTime), t.CloseReason,
+			formatFloat(t.Fees), formatFloat(t.Funding), formatFloat(t.RealisedPnl),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func entrySide(stopSide types.TradeSide) types.TradeSide {
+	if stopSide == types.TradeBuySide {
+		return types.TradeSellSide
+	}
+	return types.TradeBuySide
+}
@@ -0,0 +1,64 @@
+package market
+
+import (
+	"math"
+
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+// Impact walks the side of book a market order on side would take and returns
+// the VWAP of quantity and its distance from the mid price as a ratio. ok is
+// false when the book is not deep enough to fill quantity.
+func Impact(book types.Orderbook, side types.TradeSide, quantity float64) (vwap, impact float64, ok bool) {
+	if len(book.Bids) == 0 || len(book.Asks) == 0 || quantity <= 0 {
+		return 0, 0, false
+	}
+	mid := (book.Bids[0].Price + book.Asks[0].Price) / 2
+
+	levels := book.Asks
+	if side == types.TradeSellSide {
+		levels = book.Bids
+	}
+
+	left, cost := quantity, 0.0
+	for _, level := range levels {
+		fill := math.Min(left, level.Size)
+		cost += fill * level.Price
+		left -= fill
+		if left <= 0 {
+			break
+		}
+	}
+	if left > 0 {
+		return 0, 0, false
+	}
+
+	vwap = cost / quantity
+	return vwap, math.Abs(vwap-mid) / mid, true
+}
+
+// FitQuantity shrinks quantity in qtyStep multiples until its impact on book
+// is at most maxImpact. It returns zero if no quantity of at least minQuantity
+// fits.
+func FitQuantity(book types.Orderbook, side types.TradeSide, quantity, minQuantity, qtyStep, maxImpact float64) (fitted, impact float64) {
+	for quantity >= minQuantity && quantity > 0 {
+		_, impact, ok := Impact(book, side, quantity)
+		if ok && impact <= maxImpact {
+			return quantity, impact
+		}
+		shrunk := utils.Truncate(quantity*0.9, qtyStep)
+		if shrunk >= quantity {
+			shrunk = quantity - qtyStep
+		}
+		quantity = shrunk
+	}
+	return 0, 0
+}
+
+// ExpectedValue returns the expected edge of a trade as a ratio of the
+// notional: the funding rate minus the taker fees of entry and exit and the
+// expected slippage of the entry.
+func ExpectedValue(fundingRate float64, fees types.FeeRate, slippage float64) float64 {
+	return math.Abs(fundingRate) - 2*fees.Taker - slippage
+}
@@ -0,0 +1,15 @@
+package market
+
+import "bybit-bot/internal/types"
+
+// MaxNotional returns the largest position value the risk limit tiers allow
+// at leverage, or zero if no tier allows that much leverage.
+func MaxNotional(tiers []types.RiskLimit, leverage float64) float64 {
+	notional := 0.0
+	for _, tier := range tiers {
+		if tier.MaxLeverage >= leverage && tier.RiskLimitValue > notional {
+			notional = tier.RiskLimitValue
+		}
+	}
+	return notional
+}
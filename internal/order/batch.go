@@ -0,0 +1,77 @@
+package order
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BatchResult is the outcome of one leg of a batch request.
+type BatchResult struct {
+	Result
+	Err error
+}
+
+// BatchExecutor is an Executor that can send several orders in one request.
+type BatchExecutor interface {
+	Executor
+	CreateBatch(requests []map[string]string) ([]BatchResult, error)
+	AmendBatch(requests []map[string]string) ([]BatchResult, error)
+	CancelBatch(requests []map[string]string) ([]BatchResult, error)
+}
+
+// BatchArgs builds the body of a batch request. Bybit takes the category once
+// for the whole batch, so it is lifted out of the individual legs.
+func BatchArgs(requests []map[string]string) map[string]interface{} {
+	category := "linear"
+	legs := make([]map[string]string, 0, len(requests))
+	for _, request := range requests {
+		leg := make(map[string]string, len(request))
+		for k, v := range request {
+			if k == "category" {
+				category = v
+				continue
+			}
+			leg[k] = v
+		}
+		legs = append(legs, leg)
+	}
+	return map[string]interface{}{
+		"category": category,
+		"request":  legs,
+	}
+}
+
+// DecodeBatch pairs the per-leg acks in data with the per-leg return codes in
+// extInfo, both are in request order.
+func DecodeBatch(data, extInfo json.RawMessage, legs int) ([]BatchResult, error) {
+	var results struct {
+		List []Result `json:"list"`
+	}
+	var infos struct {
+		List []struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"list"`
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode batch result: %v", err)
+	}
+	if len(extInfo) > 0 {
+		if err := json.Unmarshal(extInfo, &infos); err != nil {
+			return nil, fmt.Errorf("failed to decode batch ext info: %v", err)
+		}
+	}
+
+	ret := make([]BatchResult, legs)
+	for i := range ret {
+		if i < len(results.List) {
+			ret[i].Result = results.List[i]
+		}
+		if i < len(infos.List) && infos.List[i].Code != 0 {
+			ret[i].Err = &APIError{Code: infos.List[i].Code, Msg: infos.List[i].Msg}
+		} else if i >= len(results.List) {
+			ret[i].Err = fmt.Errorf("no result for batch leg %d", i)
+		}
+	}
+	return ret, nil
+}
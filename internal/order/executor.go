@@ -0,0 +1,53 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnavailable is returned (possibly wrapped) by an Executor when the
+// request could not be delivered to the exchange, e.g. the websocket is down
+// or the ack timed out. The Router fails over to the next executor on it.
+var ErrUnavailable = errors.New("executor unavailable")
+
+// Executor places, amends and cancels orders on the exchange.
+type Executor interface {
+	Name() string
+	Available() bool
+	CreateOrder(params map[string]string) (Result, error)
+	AmendOrder(params map[string]string) (Result, error)
+	CancelOrder(params map[string]string) (Result, error)
+}
+
+// Result is the ack returned by the exchange for a single order request.
+type Result struct {
+	OrderId     string `json:"orderId"`
+	OrderLinkId string `json:"orderLinkId"`
+}
+
+// Matches reports whether the order update with orderId/orderLinkId refers to r.
+func (r Result) Matches(orderId, orderLinkId string) bool {
+	return (r.OrderId != "" && r.OrderId == orderId) ||
+		(r.OrderLinkId != "" && r.OrderLinkId == orderLinkId)
+}
+
+// APIError is a request that reached the exchange and was rejected by it.
+type APIError struct {
+	Code int
+	Msg  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s, return code: %d", e.Msg, e.Code)
+}
+
+const (
+	CodeOrderNotExists     = 110001
+	CodeDuplicateOrderLink = 110072
+)
+
+// IsCode reports whether err is an APIError with the given return code.
+func IsCode(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
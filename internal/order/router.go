@@ -0,0 +1,336 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+var olog = log.New(os.Stdout, "[_ORDER] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
+
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	olog.SetOutput(w)
+}
+
+// LinkIdPrefix marks every orderLinkId generated by the bot.
+const LinkIdPrefix = "frtbot-"
+
+var linkSeq atomic.Uint64
+
+// NewLinkId returns a unique orderLinkId carrying LinkIdPrefix.
+func NewLinkId() string {
+	return LinkIdPrefix +
+		strconv.FormatInt(time.Now().UnixMilli(), 36) + "-" +
+		strconv.FormatUint(linkSeq.Add(1), 36)
+}
+
+// Router sends every request to the first available executor and fails over
+// to the next one when the request could not be delivered.
+type Router struct {
+	config    *config.Config
+	executors []Executor
+}
+
+func NewRouter(cfg *config.Config, executors ...Executor) *Router {
+	return &Router{config: cfg, executors: executors}
+}
+
+func (r *Router) route(op string, params map[string]string, call func(Executor) (Result, error)) (Result, error) {
+	lastErr := ErrUnavailable
+	attempted := false
+	for _, e := range r.executors {
+		if !e.Available() {
+			olog.Printf("%s: %s executor unavailable, skipping", op, e.Name())
+			continue
+		}
+		res, err := call(e)
+		retried := attempted
+		attempted = true
+		if err == nil {
+			return res, nil
+		}
+		if !errors.Is(err, ErrUnavailable) {
+			// A create that timed out on a previous executor may have reached
+			// the exchange after all, the orderLinkId makes the retry idempotent.
+			if retried && op == "create" && IsCode(err, CodeDuplicateOrderLink) {
+				olog.Printf("%s: %s already placed through a previous executor", op, params["orderLinkId"])
+				return Result{OrderLinkId: params["orderLinkId"]}, nil
+			}
+			return Result{}, err
+		}
+		olog.Printf("%s via %s failed: %v, failing over", op, e.Name(), err)
+		lastErr = err
+	}
+	return Result{}, fmt.Errorf("%s: no executor available: %w", op, lastErr)
+}
+
+// routeBatch sends a batch to the first available executor that supports
+// batches, failing over like route. Legs rejected by the exchange are reported
+// in their BatchResult, the error is only set when the batch was not delivered.
+func (r *Router) routeBatch(op string, requests []map[string]string, call func(BatchExecutor) ([]BatchResult, error)) ([]BatchResult, error) {
+	lastErr := ErrUnavailable
+	attempted := false
+	for _, e := range r.executors {
+		be, ok := e.(BatchExecutor)
+		if !ok || !e.Available() {
+			olog.Printf("%s: %s executor unavailable, skipping", op, e.Name())
+			continue
+		}
+		results, err := call(be)
+		retried := attempted
+		attempted = true
+		if err == nil {
+			if retried && op == "create-batch" {
+				for i := range results {
+					if IsCode(results[i].Err, CodeDuplicateOrderLink) {
+						results[i] = BatchResult{Result: Result{OrderLinkId: requests[i]["orderLinkId"]}}
+					}
+				}
+			}
+			return results, nil
+		}
+		if !errors.Is(err, ErrUnavailable) {
+			return nil, err
+		}
+		olog.Printf("%s via %s failed: %v, failing over", op, e.Name(), err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("%s: no executor available: %w", op, lastErr)
+}
+
+func (r *Router) CreateOrder(params map[string]string) (Result, error) {
+	if params["orderLinkId"] == "" {
+		params["orderLinkId"] = NewLinkId()
+	}
+	olog.Printf("place order: %v", params)
+	return r.route("create", params, func(e Executor) (Result, error) { return e.CreateOrder(params) })
+}
+
+func (r *Router) AmendOrder(params map[string]string) (Result, error) {
+	olog.Printf("amend order: %v", params)
+	return r.route("amend", params, func(e Executor) (Result, error) { return e.AmendOrder(params) })
+}
+
+func (r *Router) CancelOrder(params map[string]string) (Result, error) {
+	olog.Printf("cancel order: %v", params)
+	return r.route("cancel", params, func(e Executor) (Result, error) { return e.CancelOrder(params) })
+}
+
+func (r *Router) CreateBatch(requests []map[string]string) ([]BatchResult, error) {
+	for _, params := range requests {
+		if params["orderLinkId"] == "" {
+			params["orderLinkId"] = NewLinkId()
+		}
+	}
+	olog.Printf("place batch: %v", requests)
+	return r.routeBatch("create-batch", requests, func(e BatchExecutor) ([]BatchResult, error) { return e.CreateBatch(requests) })
+}
+
+func (r *Router) AmendBatch(requests []map[string]string) ([]BatchResult, error) {
+	olog.Printf("amend batch: %v", requests)
+	return r.routeBatch("amend-batch", requests, func(e BatchExecutor) ([]BatchResult, error) { return e.AmendBatch(requests) })
+}
+
+func (r *Router) CancelBatch(requests []map[string]string) ([]BatchResult, error) {
+	olog.Printf("cancel batch: %v", requests)
+	return r.routeBatch("cancel-batch", requests, func(e BatchExecutor) ([]BatchResult, error) { return e.CancelBatch(requests) })
+}
+
+// EntryParams builds the entry order of quantity on symbol, guarded by
+// entry_max_slippage_percent around the expected price: an IOC limit at that
+// distance, or a market order with Bybit's slippage tolerance.
+func (r *Router) EntryParams(symbol string, side types.TradeSide, quantity, expectedPrice, tickSize float64) map[string]string {
+	params := map[string]string{
+		"symbol":    symbol,
+		"qty":       strconv.FormatFloat(quantity, 'f', -1, 64),
+		"side":      string(side),
+		"orderType": "Market",
+		"category":  "linear",
+	}
+
+	slippage := r.config.EntryMaxSlippage
+	if r.config.EntryOrderType == types.EntryOrderTypeIOCLimit {
+		price := expectedPrice * (1 + slippage)
+		if side == types.TradeSellSide {
+			price = expectedPrice * (1 - slippage)
+		}
+		params["orderType"] = "Limit"
+		params["timeInForce"] = "IOC"
+		params["price"] = strconv.FormatFloat(utils.Truncate(price, tickSize), 'f', -1, 64)
+	} else if slippage > 0 {
+		params["slippageToleranceType"] = "Percent"
+		params["slippageTolerance"] = strconv.FormatFloat(slippage*100, 'f', -1, 64)
+	}
+	return params
+}
+
+// AttachTPSL adds a take profit and stop loss for the whole position to an
+// entry order, so the position is protected from the moment it is opened.
+func (r *Router) AttachTPSL(params map[string]string, takeProfit, stopLoss float64) {
+	params["takeProfit"] = strconv.FormatFloat(takeProfit, 'f', -1, 64)
+	params["stopLoss"] = strconv.FormatFloat(stopLoss, 'f', -1, 64)
+	params["slTriggerBy"] = TriggerBy(r.config.StopTriggerBy)
+	params["tpslMode"] = "Full"
+}
+
+func (r *Router) CreateStopOrder(symbol string, side types.TradeSide, quantity, stopPrice, tickSize float64) (Result, error) {
+	return r.CreateOrder(r.StopParams(symbol, side, quantity, stopPrice, tickSize))
+}
+
+// CloseOrder closes quantity of a position with a reduce-only market order, or
+// with a reduce-only IOC limit at price when price is not zero.
+func (r *Router) CloseOrder(symbol string, side types.TradeSide, quantity, price float64) (Result, error) {
+	params := map[string]string{
+		"symbol":     symbol,
+		"side":       string(side),
+		"qty":        strconv.FormatFloat(quantity, 'f', -1, 64),
+		"orderType":  "Market",
+		"reduceOnly": "true",
+		"category":   "linear",
+	}
+	if price != 0 {
+		params["orderType"] = "Limit"
+		params["price"] = strconv.FormatFloat(price, 'f', -1, 64)
+		params["timeInForce"] = "IOC"
+	}
+
+	return r.CreateOrder(params)
+}
+
+func ReduceOnlyLimitParams(symbol string, side types.TradeSide, quantity, price float64) map[string]string {
+	return map[string]string{
+		"symbol":     symbol,
+		"side":       string(side),
+		"price":      strconv.FormatFloat(price, 'f', -1, 64),
+		"qty":        strconv.FormatFloat(quantity, 'f', -1, 64),
+		"orderType":  "Limit",
+		"reduceOnly": "true",
+		"category":   "linear",
+	}
+}
+
+// StopParams builds a reduce-only conditional order triggered at stopPrice,
+// using the configured order type, trigger price source and closeOnTrigger.
+func (r *Router) StopParams(symbol string, side types.TradeSide, quantity, stopPrice, tickSize float64) map[string]string {
+	triggerDirection := "1"
+	if side == types.TradeSellSide {
+		triggerDirection = "2"
+	}
+
+	params := map[string]string{
+		"symbol":           symbol,
+		"side":             string(side),
+		"orderType":        "Market",
+		"qty":              strconv.FormatFloat(quantity, 'f', -1, 64),
+		"reduceOnly":       "true",
+		"triggerPrice":     strconv.FormatFloat(stopPrice, 'f', -1, 64),
+		"triggerBy":        TriggerBy(r.config.StopTriggerBy),
+		"triggerDirection": triggerDirection,
+		"category":         "linear",
+	}
+	if r.config.StopOrderType == types.StopOrderTypeLimit {
+		params["orderType"] = "Limit"
+		params["price"] = strconv.FormatFloat(r.stopLimitPrice(side, stopPrice, tickSize), 'f', -1, 64)
+	}
+	if r.config.StopCloseOnTrigger {
+		params["closeOnTrigger"] = "true"
+	}
+	return params
+}
+
+// stopLimitPrice returns the limit price of a limit stop triggered at
+// stopPrice, leaving stop_limit_slippage_percent of room to get filled.
+func (r *Router) stopLimitPrice(side types.TradeSide, stopPrice, tickSize float64) float64 {
+	if side == types.TradeSellSide {
+		return utils.Truncate(stopPrice*(1-r.config.StopLimitSlippage), tickSize)
+	}
+	return utils.Truncate(stopPrice*(1+r.config.StopLimitSlippage), tickSize)
+}
+
+// TriggerBy returns the Bybit triggerBy value of a price source.
+func TriggerBy(source types.PriceSource) string {
+	switch source {
+	case types.PriceSourceLast:
+		return "LastPrice"
+	case types.PriceSourceIndex:
+		return "IndexPrice"
+	default:
+		return "MarkPrice"
+	}
+}
+
+// MoveStopOrder amends the trigger price of an existing stop order. If the
+// amend is rejected the stop is cancelled and placed again at stopPrice, see
+// replaceStop, and if there is no existing stop a new one is placed.
+func (r *Router) MoveStopOrder(stop Result, symbol string, side types.TradeSide, quantity, stopPrice, tickSize float64) (Result, error) {
+	if stop.OrderId == "" && stop.OrderLinkId == "" {
+		olog.Printf("no existing stop order for %s, placing a new one", symbol)
+		return r.CreateStopOrder(symbol, side, quantity, stopPrice, tickSize)
+	}
+
+	params := OrderRef(stop, symbol)
+	params["triggerPrice"] = strconv.FormatFloat(stopPrice, 'f', -1, 64)
+	if r.config.StopOrderType == types.StopOrderTypeLimit {
+		params["price"] = strconv.FormatFloat(r.stopLimitPrice(side, stopPrice, tickSize), 'f', -1, 64)
+	}
+	res, err := r.AmendOrder(params)
+	if err == nil {
+		return res, nil
+	}
+	olog.Printf("failed to amend stop order %+v: %v, falling back to cancel and replace", stop, err)
+	return r.replaceStop(stop, symbol, side, quantity, stopPrice, tickSize)
+}
+
+// replaceStop cancels stop and places a new one, but only once the cancel is
+// confirmed: the old stop is gone or no longer exists. A cancel that timed out
+// or was rejected may leave the old stop live, a second reduce-only stop next
+// to it could over-close the position, so the error is returned instead and
+// the caller keeps the old stop.
+func (r *Router) replaceStop(stop Result, symbol string, side types.TradeSide, quantity, stopPrice, tickSize float64) (Result, error) {
+	if _, err := r.Cancel(symbol, stop); err != nil && !IsCode(err, CodeOrderNotExists) {
+		return Result{}, fmt.Errorf("failed to cancel stop order %+v, not replacing it: %w", stop, err)
+	}
+	return r.CreateStopOrder(symbol, side, quantity, stopPrice, tickSize)
+}
+
+// ResizeStopOrder amends the quantity of an existing stop order, falling back
+// to cancel and replace like MoveStopOrder.
+func (r *Router) ResizeStopOrder(stop Result, symbol string, side types.TradeSide, quantity, stopPrice, tickSize float64) (Result, error) {
+	params := OrderRef(stop, symbol)
+	params["qty"] = strconv.FormatFloat(quantity, 'f', -1, 64)
+	res, err := r.AmendOrder(params)
+	if err == nil {
+		return res, nil
+	}
+	olog.Printf("failed to amend stop order %+v: %v, falling back to cancel and replace", stop, err)
+	return r.replaceStop(stop, symbol, side, quantity, stopPrice, tickSize)
+}
+
+// OrderRef identifies an existing order for amend and cancel requests.
+func OrderRef(ref Result, symbol string) map[string]string {
+	params := map[string]string{
+		"symbol":   symbol,
+		"category": "linear",
+	}
+	if ref.OrderId != "" {
+		params["orderId"] = ref.OrderId
+	} else {
+		params["orderLinkId"] = ref.OrderLinkId
+	}
+	return params
+}
+
+// Cancel cancels the order previously acked as ref.
+func (r *Router) Cancel(symbol string, ref Result) (Result, error) {
+	return r.CancelOrder(OrderRef(ref, symbol))
+}
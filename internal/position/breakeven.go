@@ -0,0 +1,74 @@
+package position
+
+import (
+	"time"
+
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+// breakeven moves the stop of pos to cost once the mark price stayed on the
+// right side of it for breakeven_window_size consecutive seconds. The mark
+// price is used because it is what Bybit triggers the stop on. With fee_aware
+// cost covers the round trip fee of a stop exit.
+func (m *Manager) breakeven(pos *Position) {
+	window := time.Duration(m.config.BreakevenWindowSize) * time.Second
+	placeDuration := time.Duration(m.config.BreakevenPlaceDuration) * time.Second
+	ratio := m.config.BreakevenPercent + StopFee(m.config, pos.Fees)
+	var delta float64
+	if pos.StopSide == types.TradeBuySide {
+		delta = pos.EntryPrice * -ratio
+	} else {
+		delta = pos.EntryPrice * ratio
+	}
+	delta = utils.Truncate(delta, pos.TickSize)
+	rawPrice := pos.EntryPrice
+	cost := rawPrice + delta
+
+	prices, unsubscribe := m.prices.Subscribe(pos.Symbol)
+	defer unsubscribe()
+	deadline := time.NewTimer(placeDuration)
+	defer deadline.Stop()
+	// re-evaluate while the price is quiet and no update arrives
+	recheck := time.NewTicker(time.Second)
+	defer recheck.Stop()
+
+	var price float64
+	var goodSince time.Time
+	for {
+		select {
+		case <-pos.closed:
+			return
+		case <-deadline.C:
+			plog.Printf("breakeven of %s not reached within %s", pos.Symbol, placeDuration)
+			return
+		case t := <-prices:
+			if t.MarkPrice == 0 {
+				continue
+			}
+			price = t.MarkPrice
+		case <-recheck.C:
+			if price == 0 {
+				continue
+			}
+		}
+
+		good := (pos.StopSide == types.TradeBuySide && price < cost) ||
+			(pos.StopSide == types.TradeSellSide && price > cost)
+		if !good {
+			if !goodSince.IsZero() {
+				plog.Printf("current(%f), cost(%f) + delta(%f), BAD, window reset", price, rawPrice, delta)
+			}
+			goodSince = time.Time{}
+			continue
+		}
+		if goodSince.IsZero() {
+			goodSince = time.Now()
+			plog.Printf("current(%f) beyond cost(%f) + delta(%f), window started", price, rawPrice, delta)
+		}
+		if time.Since(goodSince) >= window {
+			m.moveStop(pos, cost, "breakeven")
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package position
+
+import (
+	"time"
+
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+// expire force closes pos once it has been held for max_hold_duration without
+// any exit triggering.
+func (m *Manager) expire(pos *Position) {
+	maxHold := time.Duration(m.config.MaxHoldDuration) * time.Second
+	timer := time.NewTimer(time.Until(pos.OpenedAt.Add(maxHold)))
+	defer timer.Stop()
+
+	select {
+	case <-pos.closed:
+		return
+	case <-timer.C:
+	}
+
+	// closing first cancels the outstanding reduce-only exits and stops
+	// breakeven and the trailer from touching the stop again
+	m.close(pos, ReasonMaxHold)
+	m.flatten(pos)
+}
+
+// flatten closes whatever is left of pos with a reduce-only order.
+func (m *Manager) flatten(pos *Position) {
+	if m.config.ForcedExitOrderType == types.ExitOrderTypeIOCLimit {
+		if price := m.currentPrice(pos.Symbol); price != 0 {
+			if pos.StopSide == types.TradeSellSide {
+				price = price * (1 - m.config.ForcedExitSlippage)
+			} else {
+				price = price * (1 + m.config.ForcedExitSlippage)
+			}
+			price = utils.Truncate(price, pos.TickSize)
+			if size := m.positionSize(pos.Symbol); size > 0 {
+				plog.Printf("closing %v of %s with IOC limit at %f", size, pos.Symbol, price)
+				if _, err := m.orders.CloseOrder(pos.Symbol, pos.StopSide, size, price); err != nil {
+					plog.Printf("failed to close %s with IOC limit: %v", pos.Symbol, err)
+				}
+			}
+		}
+	}
+
+	// a market order takes whatever the IOC limit left
+	size := m.positionSize(pos.Symbol)
+	if size <= 0 {
+		plog.Printf("%s is flat", pos.Symbol)
+		return
+	}
+	plog.Printf("closing %v of %s with market order", size, pos.Symbol)
+	if _, err := m.orders.CloseOrder(pos.Symbol, pos.StopSide, size, 0); err != nil {
+		plog.Printf("failed to close %s with market order: %v", pos.Symbol, err)
+	}
+}
+
+// positionSize returns the size of the open position on symbol, or -1 if it
+// can't be read.
+func (m *Manager) positionSize(symbol string) float64 {
+	positions, err := m.restClient.GetPositions(symbol)
+	if err != nil {
+		plog.Printf("failed to read position of %s: %v", symbol, err)
+		return -1
+	}
+	size := 0.0
+	for _, p := range positions {
+		size += p.Size
+	}
+	return size
+}
+
+// currentPrice returns the latest last price of symbol from the price feed,
+// or zero if none arrives in time.
+func (m *Manager) currentPrice(symbol string) float64 {
+	prices, unsubscribe := m.prices.Subscribe(symbol)
+	defer unsubscribe()
+
+	timeout := time.NewTimer(2 * time.Second)
+	defer timeout.Stop()
+	for {
+		select {
+		case t := <-prices:
+			if t.LastPrice != 0 {
+				return t.LastPrice
+			}
+		case <-timeout.C:
+			plog.Printf("no price for %s from the price feed", symbol)
+			return 0
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package position
+
+import (
+	"bybit-bot/config"
+	"bybit-bot/internal/types"
+)
+
+// TakeProfitFee returns the round trip fee, as a ratio of the entry price, of
+// a position closed by its take profit. It is zero unless fee_aware is set.
+func TakeProfitFee(cfg *config.Config, fees types.FeeRate) float64 {
+	if !cfg.FeeAware {
+		return 0
+	}
+	// the take profit is a resting limit in FILL mode, a market close otherwise
+	exit := fees.Taker
+	if cfg.TPSLMode == types.TPSLModeFill {
+		exit = fees.Maker
+	}
+	return fees.Taker + exit
+}
+
+// StopFee returns the round trip fee, as a ratio of the entry price, of a
+// position closed by its stop. It is zero unless fee_aware is set.
+func StopFee(cfg *config.Config, fees types.FeeRate) float64 {
+	if !cfg.FeeAware {
+		return 0
+	}
+	return 2 * fees.Taker
+}
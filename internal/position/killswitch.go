@@ -0,0 +1,126 @@
+package position
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bybit-bot/internal/types"
+)
+
+// FlattenAll is the kill switch. It stops the manager from taking on positions,
+// closes every managed position for reason with a reduce-only market order
+// once its exits are cancelled, cancels every other open order of the bot, and
+// waits up to timeout for the position stream to report each position flat.
+// Positions that are not confirmed flat in time are returned in the error.
+func (m *Manager) FlattenAll(reason string, timeout time.Duration) error {
+	m.mu.Lock()
+	m.killed = reason
+	managed := make([]*Position, 0, len(m.positions))
+	for _, pos := range m.positions {
+		managed = append(managed, pos)
+	}
+	m.mu.Unlock()
+
+	plog.Printf("%s: flattening %d positions", reason, len(managed))
+	waiting := make(map[string]chan struct{}, len(managed))
+	for _, pos := range managed {
+		if done := m.kill(pos, reason); done != nil {
+			waiting[pos.Symbol] = done
+		}
+	}
+
+	orders, err := m.restClient.GetOpenOrders("")
+	if err != nil {
+		plog.Printf("%s: failed to read open orders: %v", reason, err)
+	}
+	bySymbol := make(map[string][]types.OrderData)
+	for _, o := range orders {
+		if isOwn(o) {
+			bySymbol[o.Symbol] = append(bySymbol[o.Symbol], o)
+		}
+	}
+	for symbol, list := range bySymbol {
+		plog.Printf("%s: cancelling %d open orders of %s", reason, len(list), symbol)
+		m.cancelOrders(symbol, refs(list))
+	}
+
+	if open := m.awaitFlat(waiting, time.Now().Add(timeout)); len(open) > 0 {
+		return fmt.Errorf("%s: not confirmed flat after %s: %s", reason, timeout, strings.Join(open, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: positions are flat, open orders unknown: %v", reason, err)
+	}
+	return nil
+}
+
+// Resume lets the manager take on positions again after FlattenAll, once the
+// risk state that halted the bot is reset. A restarted manager starts resumed.
+func (m *Manager) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.killed != "" {
+		plog.Printf("resuming after %s", m.killed)
+	}
+	m.killed = ""
+}
+
+// kill closes pos for reason, which cancels its exits and stops breakeven, the
+// trailer and the expiry, then closes the position with a reduce-only market
+// order. It returns a channel closed once the position stream reports the
+// symbol flat, or nil if the position is flat already.
+func (m *Manager) kill(pos *Position, reason string) chan struct{} {
+	m.close(pos, reason)
+
+	m.mu.Lock()
+	done := m.flattening[pos.Symbol]
+	if done == nil {
+		done = make(chan struct{})
+		m.flattening[pos.Symbol] = done
+	}
+	m.mu.Unlock()
+
+	size := m.positionSize(pos.Symbol)
+	if size == 0 {
+		m.stopFlattening(pos.Symbol)
+		plog.Printf("%s is flat", pos.Symbol)
+		return nil
+	}
+	if size < 0 {
+		// reduce-only can't overshoot the position
+		size = pos.Quantity
+	}
+	plog.Printf("%s: closing %v of %s with market order", reason, size, pos.Symbol)
+	if _, err := m.orders.CloseOrder(pos.Symbol, pos.StopSide, size, 0); err != nil {
+		plog.Printf("%s: failed to close %s: %v", reason, pos.Symbol, err)
+	}
+	return done
+}
+
+// awaitFlat waits until deadline for the position stream to report the
+// symbols in waiting flat and returns those that are still open.
+func (m *Manager) awaitFlat(waiting map[string]chan struct{}, deadline time.Time) []string {
+	var open []string
+	for symbol, done := range waiting {
+		select {
+		case <-done:
+			plog.Printf("%s is flat", symbol)
+			continue
+		case <-time.After(time.Until(deadline)):
+		}
+		// the stream may have dropped the update, the REST API has the last word
+		if m.positionSize(symbol) == 0 {
+			m.stopFlattening(symbol)
+			plog.Printf("%s is flat", symbol)
+			continue
+		}
+		open = append(open, symbol)
+	}
+	return open
+}
+
+func (m *Manager) stopFlattening(symbol string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.flattening, symbol)
+}
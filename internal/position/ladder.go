@@ -0,0 +1,74 @@
+package position
+
+import (
+	"bybit-bot/config"
+	"bybit-bot/internal/order"
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+// Rung is one take profit order of the ladder of a position.
+type Rung struct {
+	Price    float64
+	Quantity float64
+	Filled   float64
+	Order    order.Result
+}
+
+// BuildLadder splits quantity over the configured take profit rungs. Every rung
+// is a multiple of qtyStep and at least minQty, rungs that would be smaller are
+// merged into the following one and the last rung takes the remainder. Rung
+// prices include the round trip fee like ExitPrices.
+func BuildLadder(cfg *config.Config, entryPrice, quantity float64, stopSide types.TradeSide, tickSize, minQty, qtyStep float64, fees types.FeeRate) []*Rung {
+	fee := TakeProfitFee(cfg, fees)
+	var ladder []*Rung
+	carry := 0.0
+	left := quantity
+	for i, step := range cfg.TakeProfitLadder {
+		qty := utils.RoundStep(left, qtyStep)
+		if i < len(cfg.TakeProfitLadder)-1 {
+			qty = utils.Truncate(quantity*step.QtyRatio+carry, qtyStep)
+			if qty < minQty {
+				carry += quantity * step.QtyRatio
+				continue
+			}
+		}
+		if qty <= 0 {
+			continue
+		}
+		if qty < minQty && len(ladder) > 0 {
+			ladder[len(ladder)-1].Quantity += qty
+			continue
+		}
+		carry = 0
+		left -= qty
+
+		price := entryPrice * (1 + step.Ratio + fee)
+		if stopSide == types.TradeBuySide {
+			price = entryPrice * (1 - step.Ratio - fee)
+		}
+		ladder = append(ladder, &Rung{
+			Price:    utils.Truncate(price, tickSize),
+			Quantity: qty,
+		})
+	}
+	return ladder
+}
+
+// remaining returns the quantity of pos that no take profit rung has closed yet.
+func (p *Position) remaining() float64 {
+	filled := 0.0
+	for _, rung := range p.TakeProfits {
+		filled += rung.Filled
+	}
+	return utils.RoundStep(p.Quantity-filled, p.QtyStep)
+}
+
+// exits returns every exit order of pos that may still be working.
+func (p *Position) exits() []order.Result {
+	exits := make([]order.Result, 0, len(p.TakeProfits)+1)
+	for _, rung := range p.TakeProfits {
+		exits = append(exits, rung.Order)
+	}
+	return append(exits, p.Stop)
+}
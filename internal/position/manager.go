@@ -0,0 +1,641 @@
+package position
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/order"
+	"bybit-bot/internal/rest"
+	"bybit-bot/internal/store"
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+var plog = log.New(os.Stdout, "[___POS] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
+
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	plog.SetOutput(w)
+}
+
+// Reasons a position is closed for, kept in Position.CloseReason.
+const (
+	ReasonStop       = "stop filled"
+	ReasonTakeProfit = "take profit filled"
+	ReasonMaxHold    = "max hold duration reached"
+	// ReasonFlat is a position closed by an exit the bot doesn't track, such as
+	// a take profit or stop loss attached to the position
+	ReasonFlat       = "position is flat"
+	ReasonReconciled = "flat on reconciliation"
+	ReasonKilled     = "kill switch"
+	ReasonShutdown   = "shutdown"
+	// ReasonUnprotected is a position flattened because its stop could not be placed
+	ReasonUnprotected = "stop could not be placed"
+)
+
+// Position is an open position opened by the bot together with its exit orders.
+type Position struct {
+	// TradeId identifies the trade in the journal, the link id of its first entry order
+	TradeId       string
+	Symbol        string
+	StopSide      types.TradeSide
+	Quantity      float64
+	MinQty        float64
+	QtyStep       float64
+	TickSize      float64
+	EntryPrice    float64
+	ExpectedPrice float64
+	Fees          types.FeeRate
+	OpenedAt      time.Time
+	TakeProfits   []*Rung
+	Stop          order.Result
+	StopPrice     float64
+	CloseReason   string
+
+	// stopPlaced is closed once the initial stop order got its ack
+	stopPlaced chan struct{}
+	// stopMu serializes the stop moves of breakeven and the trailer
+	stopMu sync.Mutex
+	closed chan struct{}
+}
+
+// snapshot returns a copy of the position for listeners, which run while the
+// manager keeps changing the original. m.mu must be held.
+func (p *Position) snapshot() *Position {
+	rungs := make([]*Rung, len(p.TakeProfits))
+	for i, rung := range p.TakeProfits {
+		copied := *rung
+		rungs[i] = &copied
+	}
+	return &Position{
+		TradeId:       p.TradeId,
+		Symbol:        p.Symbol,
+		StopSide:      p.StopSide,
+		Quantity:      p.Quantity,
+		MinQty:        p.MinQty,
+		QtyStep:       p.QtyStep,
+		TickSize:      p.TickSize,
+		EntryPrice:    p.EntryPrice,
+		ExpectedPrice: p.ExpectedPrice,
+		Fees:          p.Fees,
+		OpenedAt:      p.OpenedAt,
+		TakeProfits:   rungs,
+		Stop:          p.Stop,
+		StopPrice:     p.StopPrice,
+		CloseReason:   p.CloseReason,
+		stopPlaced:    p.stopPlaced,
+		closed:        p.closed,
+	}
+}
+
+// Closed reports whether the position has been closed.
+func (p *Position) Closed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// PriceFeed delivers real-time ticker updates of a symbol until unsubscribed.
+type PriceFeed interface {
+	Subscribe(symbol string) (<-chan types.Ticker, func())
+}
+
+// Manager tracks the positions opened by the bot from the entry fill until
+// the position is flat, and keeps their exit orders consistent.
+type Manager struct {
+	mu         sync.Mutex
+	config     *config.Config
+	orders     *order.Router
+	restClient *rest.RestClient
+	prices     PriceFeed
+	store      *store.Store
+	// saveMu keeps snapshots of a position reaching the store in order
+	saveMu    sync.Mutex
+	pending   *entry
+	positions map[string]*Position
+	onOpen    []func(pos *Position)
+	onClose   []func(pos *Position)
+	// listeners counts the listener calls still running
+	listeners sync.WaitGroup
+	// killed is why the manager was flattened, no position is taken on while
+	// set. It lives in memory only, Resume clears it.
+	killed string
+	// flattening holds the symbols closed by the kill switch until the
+	// position stream reports them flat
+	flattening map[string]chan struct{}
+}
+
+func NewManager(cfg *config.Config, orders *order.Router, restClient *rest.RestClient, prices PriceFeed, st *store.Store) *Manager {
+	return &Manager{
+		config:     cfg,
+		orders:     orders,
+		restClient: restClient,
+		prices:     prices,
+		store:      st,
+		positions:  make(map[string]*Position),
+		flattening: make(map[string]chan struct{}),
+	}
+}
+
+// Restore picks up the positions saved by a previous run. Run Reconcile after
+// it to drop those that were closed while the bot was down.
+func (m *Manager) Restore() {
+	for symbol, data := range m.store.List(store.KindPosition) {
+		pos := &Position{
+			stopPlaced: make(chan struct{}),
+			closed:     make(chan struct{}),
+		}
+		if err := json.Unmarshal(data, pos); err != nil {
+			plog.Printf("failed to restore position %s: %v", symbol, err)
+			continue
+		}
+		if pos.TickSize == 0 {
+			// saved before the tick size was kept, prices were rounded to the min price
+			var legacy struct{ MinPrice float64 }
+			json.Unmarshal(data, &legacy)
+			pos.TickSize = legacy.MinPrice
+		}
+		close(pos.stopPlaced)
+
+		m.mu.Lock()
+		m.positions[pos.Symbol] = pos
+		m.mu.Unlock()
+		plog.Printf("restored position %s %v @ %f, stop %f, %d take profits", pos.Symbol, pos.Quantity, pos.EntryPrice, pos.StopPrice, len(pos.TakeProfits))
+		m.watch(pos)
+	}
+}
+
+// persist saves the current state of pos so a restart can pick it up again.
+func (m *Manager) persist(pos *Position) {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	if pos.Closed() {
+		return
+	}
+
+	m.mu.Lock()
+	data, err := json.Marshal(pos)
+	m.mu.Unlock()
+	if err == nil {
+		err = m.store.Put(store.KindPosition, pos.Symbol, json.RawMessage(data))
+	}
+	if err != nil {
+		plog.Printf("failed to save position %s: %v", pos.Symbol, err)
+	}
+}
+
+// forget removes a closed position from the store.
+func (m *Manager) forget(pos *Position) {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	if err := m.store.Delete(store.KindPosition, pos.Symbol); err != nil {
+		plog.Printf("failed to remove position %s: %v", pos.Symbol, err)
+	}
+}
+
+// OnOpen registers fn to be called, in its own goroutine, with a copy of
+// every position once it is opened or adopted and its stop price is set.
+func (m *Manager) OnOpen(fn func(pos *Position)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onOpen = append(m.onOpen, fn)
+}
+
+// OnClose registers fn to be called, in its own goroutine, with every
+// position once it is closed.
+func (m *Manager) OnClose(fn func(pos *Position)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onClose = append(m.onClose, fn)
+}
+
+// notifyOpen calls the open listeners with a snapshot of pos.
+func (m *Manager) notifyOpen(pos *Position) {
+	m.mu.Lock()
+	listeners, snapshot := m.onOpen, pos.snapshot()
+	m.mu.Unlock()
+	m.notify(listeners, snapshot)
+}
+
+// notify calls the listeners with pos.
+func (m *Manager) notify(listeners []func(pos *Position), pos *Position) {
+	for _, fn := range listeners {
+		m.listeners.Add(1)
+		go func(fn func(pos *Position)) {
+			defer m.listeners.Done()
+			fn(pos)
+		}(fn)
+	}
+}
+
+// entryTimeout is how long the legs of an entry, market or IOC, may take to
+// be reported done by the stream before Reconcile settles them from the
+// order history.
+const entryTimeout = time.Minute
+
+// entry is an entry that has been sent and is waiting for its legs to finish.
+type entry struct {
+	trade *types.LastTrade
+	// legs holds the final update of each leg by orderLinkId, nil while the leg is working
+	legs     map[string]*types.OrderData
+	deadline time.Time
+}
+
+// ExpectEntry registers the entry orders that are about to be placed. It must
+// be called before the orders go out, fills may reach the stream before the
+// acks reach the caller.
+func (m *Manager) ExpectEntry(trade *types.LastTrade) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	legs := make(map[string]*types.OrderData, len(trade.OrderLinkIds))
+	for _, id := range trade.OrderLinkIds {
+		legs[id] = nil
+	}
+	m.pending = &entry{trade: trade, legs: legs, deadline: time.Now().Add(entryTimeout)}
+	plog.Printf("expecting entry: %+v", trade)
+}
+
+// CancelEntry marks the given legs of the expected entry as failed to place.
+// The position is opened from the remaining legs once they are done.
+func (m *Manager) CancelEntry(orderLinkIds ...string) {
+	m.mu.Lock()
+	if m.pending == nil {
+		m.mu.Unlock()
+		return
+	}
+	for _, id := range orderLinkIds {
+		if _, ok := m.pending.legs[id]; ok {
+			m.pending.legs[id] = &types.OrderData{OrderLinkId: id}
+		}
+	}
+	trade, pos := m.settleEntry()
+	m.mu.Unlock()
+
+	if pos != nil {
+		m.open(trade, pos)
+	}
+}
+
+// OnOrderUpdate handles an update from the private order stream. It runs on
+// the read goroutine of the stream, so the state is updated in place and
+// whatever writes to the store or the exchange is handed to a goroutine: the
+// stream must keep reading, the next update may be the stop fill.
+func (m *Manager) OnOrderUpdate(data types.OrderData) {
+	m.mu.Lock()
+	if pos := m.positions[data.Symbol]; pos != nil && (data.OrderStatus == "Filled" || data.OrderStatus == "PartiallyFilled") {
+		if pos.Stop.Matches(data.OrderId, data.OrderLinkId) {
+			m.mu.Unlock()
+			if data.OrderStatus == "Filled" {
+				go m.close(pos, ReasonStop)
+			}
+			return
+		}
+		for _, rung := range pos.TakeProfits {
+			if !rung.Order.Matches(data.OrderId, data.OrderLinkId) {
+				continue
+			}
+			rung.Filled = data.CumExecQty
+			price, filled, remaining := rung.Price, rung.Filled, pos.remaining()
+			m.mu.Unlock()
+			go func() {
+				m.persist(pos)
+				if remaining < pos.MinQty {
+					m.close(pos, ReasonTakeProfit)
+				} else {
+					plog.Printf("take profit at %f of %s filled %v, %v left", price, pos.Symbol, filled, remaining)
+					m.resizeStop(pos)
+				}
+			}()
+			return
+		}
+	}
+
+	if m.pending == nil {
+		m.mu.Unlock()
+		return
+	}
+	if _, ok := m.pending.legs[data.OrderLinkId]; !ok {
+		m.mu.Unlock()
+		return
+	}
+	if !legDone(data.OrderStatus) {
+		m.mu.Unlock()
+		return
+	}
+	m.pending.legs[data.OrderLinkId] = &data
+	trade, pos := m.settleEntry()
+	m.mu.Unlock()
+
+	if pos != nil {
+		go m.open(trade, pos)
+	}
+}
+
+// legDone reports whether an entry leg with status is final. An IOC leg that
+// ran out of liquidity within the slippage guard ends Cancelled or
+// PartiallyFilledCanceled and may be partly filled either way, settleEntry
+// counts the CumExecQty of every leg whatever its status.
+func legDone(status string) bool {
+	switch status {
+	case "Filled", "PartiallyFilledCanceled", "Cancelled", "Rejected", "Deactivated":
+		return true
+	}
+	return false
+}
+
+// settleEntry opens the position of the pending entry once all its legs are
+// done, at the average price of their fills. It returns a nil position while
+// legs are still working or if none of them filled. m.mu must be held.
+func (m *Manager) settleEntry() (*types.LastTrade, *Position) {
+	trade := m.pending.trade
+	quantity, cost := 0.0, 0.0
+	for _, leg := range m.pending.legs {
+		if leg == nil {
+			return trade, nil
+		}
+		avgPrice, _ := strconv.ParseFloat(leg.AvgPrice, 64)
+		quantity += leg.CumExecQty
+		cost += leg.CumExecQty * avgPrice
+	}
+	m.pending = nil
+
+	if quantity == 0 {
+		plog.Printf("entry orders of %s done without fill", trade.Symbol)
+		return trade, nil
+	}
+
+	pos := &Position{
+		TradeId:       trade.OrderLinkIds[0],
+		Symbol:        trade.Symbol,
+		StopSide:      trade.StopSide,
+		Quantity:      utils.RoundStep(quantity, trade.QtyStep),
+		ExpectedPrice: trade.ExpectedPrice,
+		Fees:          trade.Fees,
+		MinQty:        trade.MinQty,
+		QtyStep:       trade.QtyStep,
+		TickSize:      trade.TickSize,
+		EntryPrice:    cost / quantity,
+		OpenedAt:      time.Now(),
+		stopPlaced:    make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	m.positions[pos.Symbol] = pos
+	return trade, pos
+}
+
+// open reports the fill of a new position and places its exits.
+func (m *Manager) open(trade *types.LastTrade, pos *Position) {
+	plog.Printf("position opened: %s %v @ %f", pos.Symbol, pos.Quantity, pos.EntryPrice)
+	if pos.Quantity != trade.Quantity {
+		plog.Printf("entry of %s partially filled: %v of %v", pos.Symbol, pos.Quantity, trade.Quantity)
+	}
+	if pos.ExpectedPrice != 0 {
+		slippage := (pos.EntryPrice - pos.ExpectedPrice) / pos.ExpectedPrice
+		if pos.StopSide == types.TradeBuySide {
+			slippage = -slippage
+		}
+		plog.Printf("entry slippage of %s: expected %f, filled %f, slippage %.4f%%", pos.Symbol, pos.ExpectedPrice, pos.EntryPrice, slippage*100)
+	}
+	m.mu.Lock()
+	killed := m.killed
+	m.mu.Unlock()
+	if killed != "" {
+		// an entry that was in flight when the manager was flattened
+		close(pos.stopPlaced)
+		m.notifyOpen(pos)
+		m.kill(pos, killed)
+		return
+	}
+	m.placeExits(pos)
+	m.notifyOpen(pos)
+}
+
+// OnPositionUpdate handles an update from the private position stream. A
+// position that went flat, whatever closed it, has its remaining exits cancelled.
+func (m *Manager) OnPositionUpdate(data types.PositionData) {
+	if data.Size != 0 {
+		return
+	}
+
+	m.mu.Lock()
+	if done := m.flattening[data.Symbol]; done != nil {
+		close(done)
+		delete(m.flattening, data.Symbol)
+	}
+	pos := m.positions[data.Symbol]
+	m.mu.Unlock()
+	if pos != nil {
+		// off the stream goroutine like in OnOrderUpdate
+		go m.close(pos, ReasonFlat)
+	}
+}
+
+// ExitPrices returns the stop and take profit prices of a position entered at
+// entryPrice and closed on stopSide. With fee_aware the take profit is pushed
+// out by the round trip fee so take_profit_percent is what is left after fees.
+func ExitPrices(cfg *config.Config, entryPrice float64, stopSide types.TradeSide, tickSize float64, fees types.FeeRate) (stopPrice, takeProfitPrice float64) {
+	takeProfitRatio := cfg.TakeProfitRatio + TakeProfitFee(cfg, fees)
+	if stopSide == types.TradeSellSide {
+		stopPrice = utils.Truncate(entryPrice*(1-cfg.StopRatio), tickSize)
+		takeProfitPrice = utils.Truncate(entryPrice*(1+takeProfitRatio), tickSize)
+	} else {
+		stopPrice = utils.Truncate(entryPrice*(1+cfg.StopRatio), tickSize)
+		takeProfitPrice = utils.Truncate(entryPrice*(1-takeProfitRatio), tickSize)
+	}
+	return stopPrice, takeProfitPrice
+}
+
+// watch starts the goroutines that manage the stop and the lifetime of pos.
+func (m *Manager) watch(pos *Position) {
+	if m.config.BreakevenEnabled {
+		go m.breakeven(pos)
+	}
+	if m.config.TrailingEnabled {
+		go m.trail(pos)
+	}
+	if m.config.MaxHoldDuration > 0 {
+		go m.expire(pos)
+	}
+}
+
+func (m *Manager) placeExits(pos *Position) {
+	m.watch(pos)
+
+	if m.config.TPSLMode != types.TPSLModeFill {
+		// take profit and stop loss are attached to the position itself, at
+		// the prices the entry computed from the expected price; the stop
+		// price is kept so breakeven and the trailer only ever tighten it
+		price := pos.ExpectedPrice
+		if price == 0 {
+			price = pos.EntryPrice
+		}
+		stopPrice, _ := ExitPrices(m.config, price, pos.StopSide, pos.TickSize, pos.Fees)
+		m.mu.Lock()
+		pos.StopPrice = stopPrice
+		m.mu.Unlock()
+		close(pos.stopPlaced)
+		m.persist(pos)
+		return
+	}
+
+	stopPrice, _ := ExitPrices(m.config, pos.EntryPrice, pos.StopSide, pos.TickSize, pos.Fees)
+	ladder := BuildLadder(m.config, pos.EntryPrice, pos.Quantity, pos.StopSide, pos.TickSize, pos.MinQty, pos.QtyStep, pos.Fees)
+
+	// the link ids are known before the acks, so fills racing the acks still match
+	requests := make([]map[string]string, 0, len(ladder)+1)
+	for _, rung := range ladder {
+		params := order.ReduceOnlyLimitParams(pos.Symbol, pos.StopSide, rung.Quantity, rung.Price)
+		params["orderLinkId"] = order.NewLinkId()
+		rung.Order = order.Result{OrderLinkId: params["orderLinkId"]}
+		requests = append(requests, params)
+	}
+	stop := m.orders.StopParams(pos.Symbol, pos.StopSide, pos.Quantity, stopPrice, pos.TickSize)
+	stop["orderLinkId"] = order.NewLinkId()
+	requests = append(requests, stop)
+
+	m.mu.Lock()
+	pos.TakeProfits = ladder
+	pos.Stop = order.Result{OrderLinkId: stop["orderLinkId"]}
+	pos.StopPrice = stopPrice
+	m.mu.Unlock()
+	m.persist(pos)
+
+	go func() {
+		defer close(pos.stopPlaced)
+		results, err := m.orders.CreateBatch(requests)
+		m.mu.Lock()
+		stopErr := err
+		if err != nil {
+			plog.Printf("failed to place exit orders of %s: %v", pos.Symbol, err)
+			for _, rung := range ladder {
+				rung.Order = order.Result{}
+			}
+		} else {
+			for i, rung := range ladder {
+				rung.Order = m.exitResult("take profit", results[i])
+			}
+			pos.Stop = m.exitResult("stop", results[len(ladder)])
+			stopErr = results[len(ladder)].Err
+		}
+		m.mu.Unlock()
+
+		if stopErr != nil && !m.retryStop(pos, stop) {
+			plog.Printf("ALERT: %s has no stop, flattening", pos.Symbol)
+			m.close(pos, ReasonUnprotected)
+			m.flatten(pos)
+			return
+		}
+
+		m.mu.Lock()
+		exits := pos.exits()
+		m.mu.Unlock()
+		m.persist(pos)
+		if pos.Closed() {
+			m.cancelExits(pos, exits)
+		}
+	}()
+}
+
+// retryStop places the stop of pos on its own after it failed in the exit
+// batch, the router failing over to REST if the websocket is down. The link id
+// of the batch leg is reused so a stop that did reach the exchange is not
+// placed twice. It reports whether the stop is placed.
+func (m *Manager) retryStop(pos *Position, params map[string]string) bool {
+	for i := 0; i < 3; i++ {
+		res, err := m.orders.CreateOrder(params)
+		if order.IsCode(err, order.CodeDuplicateOrderLink) {
+			res, err = order.Result{OrderLinkId: params["orderLinkId"]}, nil
+		}
+		if err == nil {
+			m.mu.Lock()
+			pos.Stop = res
+			m.mu.Unlock()
+			plog.Printf("stop of %s placed on retry", pos.Symbol)
+			return true
+		}
+		plog.Printf("failed to place stop of %s, attempt %d: %v", pos.Symbol, i+1, err)
+		time.Sleep(500 * time.Millisecond)
+	}
+	m.mu.Lock()
+	pos.Stop, pos.StopPrice = order.Result{}, 0
+	m.mu.Unlock()
+	return false
+}
+
+// exitResult returns the ack of an exit leg, or an empty Result if the leg
+// was rejected.
+func (m *Manager) exitResult(name string, res order.BatchResult) order.Result {
+	if res.Err != nil {
+		plog.Printf("failed to place %s order: %v", name, res.Err)
+		return order.Result{}
+	}
+	return res.Result
+}
+
+// cancelIfClosed cancels an exit whose ack arrived after the position was closed.
+func (m *Manager) cancelIfClosed(pos *Position, exit order.Result) {
+	if pos.Closed() {
+		m.cancelExits(pos, []order.Result{exit})
+	}
+}
+
+// close forgets the position and cancels whichever exit orders are still working.
+func (m *Manager) close(pos *Position, reason string) {
+	m.mu.Lock()
+	if pos.Closed() {
+		m.mu.Unlock()
+		return
+	}
+	pos.CloseReason = reason
+	close(pos.closed)
+	if m.positions[pos.Symbol] == pos {
+		delete(m.positions, pos.Symbol)
+	}
+	exits := pos.exits()
+	listeners := m.onClose
+	m.mu.Unlock()
+
+	plog.Printf("position %s closed: %s", pos.Symbol, reason)
+	m.forget(pos)
+	m.cancelExits(pos, exits)
+	m.notify(listeners, pos)
+}
+
+// cancelExits cancels the given exit orders of pos in one batch.
+func (m *Manager) cancelExits(pos *Position, exits []order.Result) {
+	m.cancelOrders(pos.Symbol, exits)
+}
+
+// cancelOrders cancels the given orders on symbol in one batch. Orders that
+// are already gone are not an error.
+func (m *Manager) cancelOrders(symbol string, refs []order.Result) {
+	var requests []map[string]string
+	for _, ref := range refs {
+		if ref.OrderId != "" || ref.OrderLinkId != "" {
+			requests = append(requests, order.OrderRef(ref, symbol))
+		}
+	}
+	if len(requests) == 0 {
+		return
+	}
+
+	results, err := m.orders.CancelBatch(requests)
+	if err != nil {
+		plog.Printf("failed to cancel orders of %s: %v", symbol, err)
+		return
+	}
+	for i, res := range results {
+		if res.Err != nil && !order.IsCode(res.Err, order.CodeOrderNotExists) {
+			plog.Printf("failed to cancel order %v of %s: %v", requests[i], symbol, res.Err)
+		}
+	}
+}
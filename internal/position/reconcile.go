@@ -0,0 +1,320 @@
+package position
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"bybit-bot/internal/order"
+	"bybit-bot/internal/types"
+)
+
+// Reconcile compares the positions and open orders on the exchange with what
+// the manager tracks. Positions of the bot that it doesn't track, left behind
+// by a crash, are adopted together with their exit orders, and orphaned exit
+// orders of the bot are cancelled. Positions and orders that aren't the bot's
+// are handled by reconcile_policy. An entry whose legs the stream didn't
+// report done in time is settled from the order history first.
+func (m *Manager) Reconcile() error {
+	m.settleStaleEntry()
+
+	snapshot := time.Now()
+	positions, err := m.restClient.GetPositions("")
+	if err != nil {
+		return err
+	}
+	orders, err := m.restClient.GetOpenOrders("")
+	if err != nil {
+		return err
+	}
+
+	bySymbol := make(map[string][]types.OrderData)
+	for _, data := range orders {
+		bySymbol[data.Symbol] = append(bySymbol[data.Symbol], data)
+	}
+
+	open := make(map[string]bool)
+	for _, data := range positions {
+		open[data.Symbol] = true
+		if m.tracked(data.Symbol) {
+			continue
+		}
+		if m.owns(data, bySymbol[data.Symbol]) {
+			m.adopt(data, bySymbol[data.Symbol])
+			continue
+		}
+		m.foreignPosition(data, bySymbol[data.Symbol])
+	}
+
+	// positions that went flat while the stream was not listening; those
+	// opened after the snapshot are simply not in it yet
+	var flat []*Position
+	m.mu.Lock()
+	for symbol, pos := range m.positions {
+		if !open[symbol] && pos.OpenedAt.Before(snapshot) {
+			flat = append(flat, pos)
+		}
+	}
+	m.mu.Unlock()
+	for _, pos := range flat {
+		m.close(pos, ReasonReconciled)
+	}
+
+	for symbol, list := range bySymbol {
+		if !open[symbol] && !m.tracked(symbol) {
+			m.strayOrders(symbol, list)
+		}
+	}
+	return nil
+}
+
+// ReconcileEvery runs Reconcile every interval until stop is closed.
+func (m *Manager) ReconcileEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := m.Reconcile(); err != nil {
+			plog.Printf("failed to reconcile: %v", err)
+		}
+	}
+}
+
+// settleStaleEntry settles the pending entry from the order history once its
+// deadline passed, the stream may have dropped the final update of a leg
+// while reconnecting. A leg missing from the history never reached the
+// exchange. A leg still working is left to the stream.
+func (m *Manager) settleStaleEntry() {
+	m.mu.Lock()
+	pending := m.pending
+	m.mu.Unlock()
+	if pending == nil || time.Now().Before(pending.deadline) {
+		return
+	}
+
+	history, err := m.restClient.GetOrderHistory(pending.trade.Symbol, 50)
+	if err != nil {
+		plog.Printf("failed to read order history of stale entry on %s: %v", pending.trade.Symbol, err)
+		return
+	}
+	byLinkId := make(map[string]types.OrderData, len(history))
+	for _, o := range history {
+		byLinkId[o.OrderLinkId] = o
+	}
+
+	m.mu.Lock()
+	if m.pending != pending {
+		// settled by the stream meanwhile
+		m.mu.Unlock()
+		return
+	}
+	for id, leg := range pending.legs {
+		if leg != nil {
+			continue
+		}
+		o, ok := byLinkId[id]
+		switch {
+		case !ok:
+			pending.legs[id] = &types.OrderData{OrderLinkId: id}
+		case legDone(o.OrderStatus):
+			pending.legs[id] = &o
+		}
+	}
+	plog.Printf("settling stale entry on %s from the order history", pending.trade.Symbol)
+	trade, pos := m.settleEntry()
+	m.mu.Unlock()
+
+	if pos != nil {
+		m.open(trade, pos)
+	}
+}
+
+// tracked reports whether the manager holds a position on symbol or is
+// waiting for an entry on it.
+func (m *Manager) tracked(symbol string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.positions[symbol] != nil || (m.pending != nil && m.pending.trade.Symbol == symbol)
+}
+
+// owns reports whether the position was opened by the bot: one of its open
+// orders, or the latest order that opened it, carries the bot's link id prefix.
+func (m *Manager) owns(data types.PositionData, orders []types.OrderData) bool {
+	for _, o := range orders {
+		if isOwn(o) {
+			return true
+		}
+	}
+	history, err := m.restClient.GetOrderHistory(data.Symbol, 20)
+	if err != nil {
+		plog.Printf("failed to read order history of %s: %v", data.Symbol, err)
+		return false
+	}
+	for _, o := range history {
+		if !o.ReduceOnly && o.CumExecQty > 0 {
+			return isOwn(o)
+		}
+	}
+	return false
+}
+
+func isOwn(o types.OrderData) bool {
+	return strings.HasPrefix(o.OrderLinkId, order.LinkIdPrefix)
+}
+
+// adopt starts tracking a position the manager doesn't know about. Exit orders
+// of the bot that are still working are taken over, if the stop is missing
+// the exits are placed anew.
+func (m *Manager) adopt(data types.PositionData, orders []types.OrderData) {
+	// the snapshot may be stale by now
+	if m.positionSize(data.Symbol) <= 0 {
+		return
+	}
+	info, err := m.instrument(data.Symbol)
+	if err != nil {
+		plog.Printf("failed to adopt %s: %v", data.Symbol, err)
+		return
+	}
+
+	stopSide := types.TradeSellSide
+	if data.Side == string(types.TradeSellSide) {
+		stopSide = types.TradeBuySide
+	}
+	openedAt := time.Now()
+	if ms, err := strconv.ParseInt(data.CreatedTime, 10, 64); err == nil && ms > 0 {
+		openedAt = time.UnixMilli(ms)
+	}
+	pos := &Position{
+		TradeId:    fmt.Sprintf("adopted-%s-%d", data.Symbol, openedAt.UnixMilli()),
+		Symbol:     data.Symbol,
+		StopSide:   stopSide,
+		Quantity:   data.Size,
+		MinQty:     info.MinQty,
+		QtyStep:    info.QtyStep,
+		TickSize:   info.TickSize,
+		EntryPrice: data.EntryPrice,
+		OpenedAt:   openedAt,
+		stopPlaced: make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	if m.config.FeeAware {
+		if pos.Fees, err = m.restClient.GetFeeRate(data.Symbol); err != nil {
+			plog.Printf("Warning: %v, adopting %s without fees", err, data.Symbol)
+		}
+	}
+
+	for _, o := range orders {
+		if !isOwn(o) || !o.ReduceOnly {
+			continue
+		}
+		ref := order.Result{OrderId: o.OrderId, OrderLinkId: o.OrderLinkId}
+		triggerPrice, _ := strconv.ParseFloat(o.TriggerPrice, 64)
+		if triggerPrice > 0 {
+			if pos.StopPrice == 0 {
+				pos.Stop, pos.StopPrice = ref, triggerPrice
+			}
+			continue
+		}
+		price, _ := strconv.ParseFloat(o.Price, 64)
+		pos.TakeProfits = append(pos.TakeProfits, &Rung{Price: price, Quantity: o.Qty, Filled: o.CumExecQty, Order: ref})
+		pos.Quantity += o.CumExecQty
+	}
+
+	replace := m.config.TPSLMode == types.TPSLModeFill && pos.StopPrice == 0
+	if replace {
+		m.cancelExits(pos, pos.exits())
+		pos.TakeProfits, pos.Quantity = nil, data.Size
+	}
+
+	m.mu.Lock()
+	if m.positions[pos.Symbol] != nil || m.killed != "" {
+		m.mu.Unlock()
+		return
+	}
+	m.positions[pos.Symbol] = pos
+	m.mu.Unlock()
+
+	plog.Printf("adopted position %s %s %v @ %f, stop %f, %d take profits", pos.Symbol, data.Side, data.Size, pos.EntryPrice, pos.StopPrice, len(pos.TakeProfits))
+	if replace {
+		m.placeExits(pos)
+	} else {
+		close(pos.stopPlaced)
+		m.persist(pos)
+		m.watch(pos)
+	}
+	m.notifyOpen(pos)
+}
+
+// instrument returns the trading rules of symbol.
+func (m *Manager) instrument(symbol string) (types.Exchange, error) {
+	symbols, err := m.restClient.GetAllSymbols()
+	if err != nil {
+		return types.Exchange{}, err
+	}
+	for _, s := range symbols {
+		if s.Symbol == symbol {
+			return s, nil
+		}
+	}
+	return types.Exchange{}, fmt.Errorf("unknown symbol %s", symbol)
+}
+
+// foreignPosition applies reconcile_policy to a position the bot didn't open.
+func (m *Manager) foreignPosition(data types.PositionData, orders []types.OrderData) {
+	switch m.config.ReconcilePolicy {
+	case types.ReconcilePolicyAdopt:
+		m.adopt(data, orders)
+	case types.ReconcilePolicyClose:
+		plog.Printf("closing unknown position %s %s %v @ %f", data.Symbol, data.Side, data.Size, data.EntryPrice)
+		m.cancelOrders(data.Symbol, refs(orders))
+		side := types.TradeSellSide
+		if data.Side == string(types.TradeSellSide) {
+			side = types.TradeBuySide
+		}
+		if _, err := m.orders.CloseOrder(data.Symbol, side, data.Size, 0); err != nil {
+			plog.Printf("failed to close unknown position %s: %v", data.Symbol, err)
+		}
+	default:
+		plog.Printf("ALERT: unknown position %s %s %v @ %f with %d open orders", data.Symbol, data.Side, data.Size, data.EntryPrice, len(orders))
+	}
+}
+
+// strayOrders handles open orders on a symbol without a position. Those of the
+// bot are exits left behind by a closed position and are cancelled, the others
+// are handled by reconcile_policy.
+func (m *Manager) strayOrders(symbol string, orders []types.OrderData) {
+	var own, foreign []types.OrderData
+	for _, o := range orders {
+		if isOwn(o) {
+			own = append(own, o)
+		} else {
+			foreign = append(foreign, o)
+		}
+	}
+	if len(own) > 0 {
+		plog.Printf("cancelling %d orphaned orders of %s", len(own), symbol)
+		m.cancelOrders(symbol, refs(own))
+	}
+	if len(foreign) == 0 {
+		return
+	}
+	if m.config.ReconcilePolicy == types.ReconcilePolicyClose {
+		plog.Printf("cancelling %d unknown orders of %s", len(foreign), symbol)
+		m.cancelOrders(symbol, refs(foreign))
+		return
+	}
+	plog.Printf("ALERT: %d unknown open orders on %s", len(foreign), symbol)
+}
+
+func refs(orders []types.OrderData) []order.Result {
+	refs := make([]order.Result, 0, len(orders))
+	for _, o := range orders {
+		refs = append(refs, order.Result{OrderId: o.OrderId, OrderLinkId: o.OrderLinkId})
+	}
+	return refs
+}
@@ -0,0 +1,93 @@
+package position
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bybit-bot/internal/types"
+)
+
+// Shutdown gets the manager ready for the process to exit. It waits for a
+// pending entry to settle and for the exits of every position to be acked,
+// then flattens every position when flatten is set, or only those left
+// without a stop otherwise. Last it waits for the open and close listeners to
+// return. Each step waits up to timeout.
+func (m *Manager) Shutdown(flatten bool, timeout time.Duration) error {
+	if !m.settled(time.Now().Add(timeout)) {
+		plog.Printf("shutdown: entry or exits still in flight after %s", timeout)
+	}
+
+	var err error
+	if flatten {
+		err = m.FlattenAll(ReasonShutdown, timeout)
+	} else {
+		err = m.flattenUnprotected(time.Now().Add(timeout))
+	}
+
+	listeners := make(chan struct{})
+	go func() {
+		m.listeners.Wait()
+		close(listeners)
+	}()
+	select {
+	case <-listeners:
+	case <-time.After(timeout):
+		plog.Printf("shutdown: listeners still running after %s", timeout)
+	}
+	return err
+}
+
+// settled waits until deadline for the pending entry to settle and for the
+// initial exits of every position to be acked, and reports whether they were.
+func (m *Manager) settled(deadline time.Time) bool {
+	for {
+		m.mu.Lock()
+		done := m.pending == nil
+		for _, pos := range m.positions {
+			select {
+			case <-pos.stopPlaced:
+			default:
+				done = false
+			}
+		}
+		m.mu.Unlock()
+		if done {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// flattenUnprotected closes the positions that have no stop working on the
+// exchange, which nothing would protect once the bot is gone. With tpsl_mode
+// ORDER or TRADING_STOP the stop is attached to the position itself.
+func (m *Manager) flattenUnprotected(deadline time.Time) error {
+	if m.config.TPSLMode != types.TPSLModeFill {
+		return nil
+	}
+
+	var unprotected []*Position
+	m.mu.Lock()
+	for _, pos := range m.positions {
+		if pos.StopPrice == 0 || (pos.Stop.OrderId == "" && pos.Stop.OrderLinkId == "") {
+			unprotected = append(unprotected, pos)
+		}
+	}
+	m.mu.Unlock()
+
+	waiting := make(map[string]chan struct{}, len(unprotected))
+	for _, pos := range unprotected {
+		plog.Printf("shutdown: %s has no stop, flattening", pos.Symbol)
+		if done := m.kill(pos, ReasonShutdown); done != nil {
+			waiting[pos.Symbol] = done
+		}
+	}
+	if open := m.awaitFlat(waiting, deadline); len(open) > 0 {
+		return fmt.Errorf("shutdown: unprotected positions not confirmed flat: %s", strings.Join(open, ", "))
+	}
+	return nil
+}
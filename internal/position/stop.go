@@ -0,0 +1,101 @@
+package position
+
+import (
+	"bybit-bot/internal/types"
+)
+
+// tighter reports whether a stop at price is closer to the market than a stop
+// at current, i.e. whether moving the stop there locks in more profit.
+func tighter(stopSide types.TradeSide, price, current float64) bool {
+	if stopSide == types.TradeSellSide {
+		return price > current
+	}
+	return price < current
+}
+
+// moveStop moves the stop of pos to stopPrice once the initial stop is acked.
+// The stop is only ever tightened, so breakeven and the trailer can't undo
+// each other.
+func (m *Manager) moveStop(pos *Position, stopPrice float64, reason string) {
+	<-pos.stopPlaced
+	pos.stopMu.Lock()
+	defer pos.stopMu.Unlock()
+	if pos.Closed() {
+		return
+	}
+
+	m.mu.Lock()
+	stop, current, quantity := pos.Stop, pos.StopPrice, pos.remaining()
+	m.mu.Unlock()
+	if current != 0 && !tighter(pos.StopSide, stopPrice, current) {
+		plog.Printf("%s: stop of %s already at %f, not moving to %f", reason, pos.Symbol, current, stopPrice)
+		return
+	}
+
+	if m.config.TPSLMode != types.TPSLModeFill {
+		plog.Printf("%s: moving position stop loss of %s to %f", reason, pos.Symbol, stopPrice)
+		if err := m.restClient.SetTradingStop(pos.Symbol, 0, stopPrice); err != nil {
+			plog.Printf("%s: failed to move stop loss: %v", reason, err)
+			return
+		}
+		m.mu.Lock()
+		pos.StopPrice = stopPrice
+		m.mu.Unlock()
+		m.persist(pos)
+		return
+	}
+
+	plog.Printf("%s: moving stop order of %s to %f", reason, pos.Symbol, stopPrice)
+	res, err := m.orders.MoveStopOrder(
+		stop,         // existing stop
+		pos.Symbol,   // symbol
+		pos.StopSide, // side
+		quantity,     // quantity
+		stopPrice,    // stop price
+		pos.TickSize, // tick size
+	)
+	if err != nil {
+		plog.Printf("%s: failed to move stop order: %v", reason, err)
+		return
+	}
+
+	m.mu.Lock()
+	pos.Stop = res
+	pos.StopPrice = stopPrice
+	m.mu.Unlock()
+	m.persist(pos)
+	m.cancelIfClosed(pos, res)
+}
+
+// resizeStop shrinks the stop of pos to what is left of the position after a
+// take profit rung filled. If the amend is rejected the stop is cancelled and
+// placed again. The remaining quantity is read under stopMu, so resizes
+// started by fills that race each other always leave the latest size.
+func (m *Manager) resizeStop(pos *Position) {
+	<-pos.stopPlaced
+	pos.stopMu.Lock()
+	defer pos.stopMu.Unlock()
+	if pos.Closed() || m.config.TPSLMode != types.TPSLModeFill {
+		return
+	}
+
+	m.mu.Lock()
+	stop, stopPrice, quantity := pos.Stop, pos.StopPrice, pos.remaining()
+	m.mu.Unlock()
+	if stop.OrderId == "" && stop.OrderLinkId == "" {
+		return
+	}
+
+	plog.Printf("resizing stop order of %s to %v", pos.Symbol, quantity)
+	res, err := m.orders.ResizeStopOrder(stop, pos.Symbol, pos.StopSide, quantity, stopPrice, pos.TickSize)
+	if err != nil {
+		plog.Printf("failed to resize stop order: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	pos.Stop = res
+	m.mu.Unlock()
+	m.persist(pos)
+	m.cancelIfClosed(pos, res)
+}
@@ -0,0 +1,78 @@
+package position
+
+import (
+	"math"
+	"time"
+
+	"bybit-bot/internal/types"
+	"bybit-bot/internal/utils"
+)
+
+// trail runs the trailing stop of pos until it is closed.
+func (m *Manager) trail(pos *Position) {
+	activation := pos.EntryPrice * m.config.TrailingActivation
+	distance := utils.Truncate(pos.EntryPrice*m.config.TrailingDistance, pos.TickSize)
+	step := pos.EntryPrice * m.config.TrailingStep
+	activePrice := pos.EntryPrice + activation
+	if pos.StopSide == types.TradeBuySide {
+		activePrice = pos.EntryPrice - activation
+	}
+	activePrice = utils.Truncate(activePrice, pos.TickSize)
+
+	if m.config.TrailingMode == types.TrailingModeNative {
+		// Bybit only accepts a trailing stop once the position is visible
+		for i := 0; i < 5; i++ {
+			err := m.restClient.SetTrailingStop(pos.Symbol, distance, activePrice)
+			if err == nil {
+				plog.Printf("native trailing stop set for %s: distance %f, active at %f", pos.Symbol, distance, activePrice)
+				return
+			}
+			plog.Printf("failed to set native trailing stop for %s: %v", pos.Symbol, err)
+			time.Sleep(200 * time.Millisecond)
+		}
+		return
+	}
+
+	prices, unsubscribe := m.prices.Subscribe(pos.Symbol)
+	defer unsubscribe()
+
+	var best, trailed float64
+	for {
+		var t types.Ticker
+		select {
+		case <-pos.closed:
+			return
+		case t = <-prices:
+		}
+
+		price := t.MarkPrice
+		if m.config.TrailingPriceSource == types.PriceSourceLast {
+			price = t.LastPrice
+		}
+		if price == 0 {
+			continue
+		}
+
+		if best == 0 {
+			// tighter doubles as "further in profit" for prices
+			if price != activePrice && !tighter(pos.StopSide, price, activePrice) {
+				continue
+			}
+			plog.Printf("trailing stop of %s activated at %f", pos.Symbol, price)
+		} else if !tighter(pos.StopSide, price, best) {
+			continue
+		}
+		best = price
+
+		stopPrice := best - distance
+		if pos.StopSide == types.TradeBuySide {
+			stopPrice = best + distance
+		}
+		stopPrice = utils.Truncate(stopPrice, pos.TickSize)
+		if trailed != 0 && (!tighter(pos.StopSide, stopPrice, trailed) || math.Abs(stopPrice-trailed) < step) {
+			continue
+		}
+		trailed = stopPrice
+		m.moveStop(pos, stopPrice, "trailing stop")
+	}
+}
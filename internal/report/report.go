@@ -0,0 +1,271 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"bybit-bot/internal/journal"
+	"bybit-bot/internal/position"
+	"bybit-bot/internal/rest"
+	"bybit-bot/internal/types"
+)
+
+// Exit reasons a trade is grouped by.
+const (
+	ExitTakeProfit = "TP"
+	ExitStopLoss   = "SL"
+	ExitBreakeven  = "breakeven"
+	ExitTimeout    = "timeout"
+	ExitKilled     = "kill switch"
+	ExitShutdown   = "shutdown"
+	// ExitUnprotected is a position flattened because its stop could not be placed
+	ExitUnprotected = "unprotected"
+	// ExitExternal is closed PnL on Bybit that no journaled trade accounts for
+	ExitExternal = "external"
+)
+
+// Row aggregates a group of closed trades. AvgR is the average PnL in units
+// of the initial risk, stop_percent of the entry notional; trades without an
+// entry in the journal don't count towards it.
+type Row struct {
+	Key     string  `json:"key"`
+	Trades  int     `json:"trades"`
+	Wins    int     `json:"wins"`
+	WinRate float64 `json:"win_rate"`
+	Pnl     float64 `json:"pnl"`
+	AvgR    float64 `json:"avg_r"`
+	Fees    float64 `json:"fees"`
+	Funding float64 `json:"funding"`
+
+	rSum   float64
+	rCount int
+}
+
+type Report struct {
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Total        Row       `json:"total"`
+	BySymbol     []Row     `json:"by_symbol"`
+	ByDay        []Row     `json:"by_day"`
+	ByFundingBin []Row     `json:"by_funding_rate"`
+	ByExitReason []Row     `json:"by_exit_reason"`
+}
+
+// closedTrade is a trade reduced to what the report aggregates.
+type closedTrade struct {
+	symbol      string
+	exitTime    time.Time
+	fundingRate float64
+	reason      string
+	pnl         float64
+	fees        float64
+	funding     float64
+	r           float64
+	hasR        bool
+}
+
+// ClosedPnl returns the closed PnL records on Bybit between from and to,
+// fetched in the seven day windows Bybit allows.
+func ClosedPnl(restClient *rest.RestClient, from, to time.Time) ([]types.ClosedPnl, error) {
+	var records []types.ClosedPnl
+	for start := from; start.Before(to); start = start.Add(7 * 24 * time.Hour) {
+		end := start.Add(7 * 24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		list, err := restClient.GetClosedPnl("", start, end)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, list...)
+	}
+	return records, nil
+}
+
+// Build aggregates the journaled trades closed between from and to. Closed
+// PnL records on Bybit that fall outside every journaled trade are added as
+// external trades, one per record.
+func Build(trades []journal.Trade, records []types.ClosedPnl, from, to time.Time, stopRatio float64) Report {
+	var closed []closedTrade
+	for _, t := range trades {
+		if t.Status != journal.StatusClosed || t.ExitTime.Before(from) || t.ExitTime.After(to) {
+			continue
+		}
+		c := closedTrade{
+			symbol:      t.Symbol,
+			exitTime:    t.ExitTime,
+			fundingRate: t.FundingRateSeen,
+			reason:      exitReason(t),
+			pnl:         t.RealisedPnl,
+			fees:        t.Fees,
+			funding:     t.Funding,
+		}
+		if risk := t.EntryPrice * t.Quantity * stopRatio; risk > 0 {
+			c.r, c.hasR = t.RealisedPnl/risk, true
+		}
+		closed = append(closed, c)
+	}
+
+	for _, record := range records {
+		exitTime := time.UnixMilli(record.CreatedTime)
+		if journaled(trades, record.Symbol, exitTime) {
+			continue
+		}
+		closed = append(closed, closedTrade{
+			symbol:   record.Symbol,
+			exitTime: exitTime,
+			reason:   ExitExternal,
+			pnl:      record.ClosedPnl,
+		})
+	}
+
+	report := Report{
+		From:         from,
+		To:           to,
+		Total:        total(closed),
+		BySymbol:     aggregate(closed, func(c closedTrade) string { return c.symbol }),
+		ByDay:        aggregate(closed, func(c closedTrade) string { return c.exitTime.UTC().Format("2006-01-02") }),
+		ByFundingBin: aggregate(closed, func(c closedTrade) string { return fundingBin(c) }),
+		ByExitReason: aggregate(closed, func(c closedTrade) string { return c.reason }),
+	}
+	return report
+}
+
+// journaled reports whether a closed PnL record of symbol at exitTime belongs
+// to a trade in the journal.
+func journaled(trades []journal.Trade, symbol string, exitTime time.Time) bool {
+	for _, t := range trades {
+		if t.Symbol != symbol || t.EntryTime.IsZero() || exitTime.Before(t.EntryTime) {
+			continue
+		}
+		if t.ExitTime.IsZero() || !exitTime.After(t.ExitTime.Add(time.Minute)) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitReason maps the close reason of a trade to TP, SL, breakeven, timeout,
+// or the kill switch, shutdown and unprotected flattens.
+// A stop that filled at or beyond the entry price was moved there by
+// breakeven or the trailer. Exits the bot doesn't track, such as a take profit
+// attached to the position, are told apart by the sign of the PnL.
+func exitReason(t journal.Trade) string {
+	lockedIn := t.StopPrice != 0 && ((t.Side == string(types.TradeBuySide) && t.StopPrice >= t.EntryPrice) ||
+		(t.Side == string(types.TradeSellSide) && t.StopPrice <= t.EntryPrice))
+	switch t.CloseReason {
+	case position.ReasonMaxHold:
+		return ExitTimeout
+	case position.ReasonTakeProfit:
+		return ExitTakeProfit
+	case position.ReasonKilled:
+		return ExitKilled
+	case position.ReasonShutdown:
+		return ExitShutdown
+	case position.ReasonUnprotected:
+		return ExitUnprotected
+	case position.ReasonStop:
+		if lockedIn {
+			return ExitBreakeven
+		}
+		return ExitStopLoss
+	}
+	if t.RealisedPnl > 0 {
+		return ExitTakeProfit
+	}
+	if lockedIn {
+		return ExitBreakeven
+	}
+	return ExitStopLoss
+}
+
+var fundingBins = []float64{0.001, 0.0025, 0.005, 0.01}
+
+// fundingBin buckets the absolute funding rate seen at selection time.
+func fundingBin(c closedTrade) string {
+	if c.reason == ExitExternal {
+		return "unknown"
+	}
+	rate := math.Abs(c.fundingRate)
+	lower := 0.0
+	for _, upper := range fundingBins {
+		if rate < upper {
+			return fmt.Sprintf("%.2f%%-%.2f%%", lower*100, upper*100)
+		}
+		lower = upper
+	}
+	return fmt.Sprintf(">=%.2f%%", lower*100)
+}
+
+// total sums up every trade in one row, a zero row if there are none.
+func total(trades []closedTrade) Row {
+	rows := aggregate(trades, func(closedTrade) string { return "total" })
+	if len(rows) == 0 {
+		return Row{Key: "total"}
+	}
+	return rows[0]
+}
+
+func aggregate(trades []closedTrade, key func(closedTrade) string) []Row {
+	rows := make(map[string]*Row)
+	for _, c := range trades {
+		k := key(c)
+		row := rows[k]
+		if row == nil {
+			row = &Row{Key: k}
+			rows[k] = row
+		}
+		row.Trades++
+		if c.pnl > 0 {
+			row.Wins++
+		}
+		row.Pnl += c.pnl
+		row.Fees += c.fees
+		row.Funding += c.funding
+		if c.hasR {
+			row.rSum += c.r
+			row.rCount++
+		}
+	}
+
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		row.WinRate = float64(row.Wins) / float64(row.Trades)
+		if row.rCount > 0 {
+			row.AvgR = row.rSum / float64(row.rCount)
+		}
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Key < result[b].Key })
+	return result
+}
+
+// PrintTable writes the report as aligned text tables.
+func (r Report) PrintTable(w io.Writer) {
+	fmt.Fprintf(w, "closed trades from %s to %s\n", r.From.UTC().Format(time.RFC3339), r.To.UTC().Format(time.RFC3339))
+	sections := []struct {
+		title string
+		rows  []Row
+	}{
+		{"total", []Row{r.Total}},
+		{"symbol", r.BySymbol},
+		{"day", r.ByDay},
+		{"funding rate", r.ByFundingBin},
+		{"exit reason", r.ByExitReason},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(w, "\n%s\n", strings.ToUpper(section.title))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "key\ttrades\twin rate\tpnl\tavg R\tfees\tfunding\t")
+		for _, row := range section.rows {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%.4f\t%.2f\t%.4f\t%.4f\t\n",
+				row.Key, row.Trades, row.WinRate*100, row.Pnl, row.AvgR, row.Fees, row.Funding)
+		}
+		tw.Flush()
+	}
+}
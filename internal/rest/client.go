@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 
 	"bybit-bot/config"
 	"bybit-bot/internal/constant"
+	"bybit-bot/internal/order"
 	"bybit-bot/internal/types"
 	"bybit-bot/internal/utils"
 )
@@ -29,6 +31,11 @@ type RestClient struct {
 
 var rlog = log.New(os.Stdout, "[__REST] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
 
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	rlog.SetOutput(w)
+}
+
 const binanceBaseURL = "https://fapi.binance.com"
 const recvWindow = "5000"
 
@@ -93,7 +100,90 @@ func (c *RestClient) postRequest(params interface{}, endPoint string) (*http.Res
 	return response, nil
 }
 
-func (c *RestClient) SetLeverage(leverage int, symbol string) {
+func (c *RestClient) Name() string {
+	return "rest"
+}
+
+func (c *RestClient) Available() bool {
+	return true
+}
+
+func (c *RestClient) CreateOrder(params map[string]string) (order.Result, error) {
+	return c.orderRequest("/v5/order/create", params)
+}
+
+func (c *RestClient) AmendOrder(params map[string]string) (order.Result, error) {
+	return c.orderRequest("/v5/order/amend", params)
+}
+
+func (c *RestClient) CancelOrder(params map[string]string) (order.Result, error) {
+	return c.orderRequest("/v5/order/cancel", params)
+}
+
+func (c *RestClient) CreateBatch(requests []map[string]string) ([]order.BatchResult, error) {
+	return c.batchRequest("/v5/order/create-batch", requests)
+}
+
+func (c *RestClient) AmendBatch(requests []map[string]string) ([]order.BatchResult, error) {
+	return c.batchRequest("/v5/order/amend-batch", requests)
+}
+
+func (c *RestClient) CancelBatch(requests []map[string]string) ([]order.BatchResult, error) {
+	return c.batchRequest("/v5/order/cancel-batch", requests)
+}
+
+func (c *RestClient) batchRequest(endPoint string, requests []map[string]string) ([]order.BatchResult, error) {
+	resp, err := c.postRequest(order.BatchArgs(requests), endPoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", order.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code       int             `json:"retCode"`
+		Msg        string          `json:"retMsg"`
+		Result     json.RawMessage `json:"result"`
+		RetExtInfo json.RawMessage `json:"retExtInfo"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return nil, &order.APIError{Code: result.Code, Msg: result.Msg}
+	}
+
+	return order.DecodeBatch(result.Result, result.RetExtInfo, len(requests))
+}
+
+func (c *RestClient) orderRequest(endPoint string, params map[string]string) (order.Result, error) {
+	resp, err := c.postRequest(params, endPoint)
+	if err != nil {
+		return order.Result{}, fmt.Errorf("%w: %v", order.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code   int          `json:"retCode"`
+		Msg    string       `json:"retMsg"`
+		Result order.Result `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return order.Result{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return order.Result{}, &order.APIError{Code: result.Code, Msg: result.Msg}
+	}
+
+	return result.Result, nil
+}
+
+// SetLeverage sets the buy and sell leverage of symbol. Leverage that is
+// already set (110043) is not an error.
+func (c *RestClient) SetLeverage(leverage int, symbol string) error {
 	endPoint := "/v5/position/set-leverage"
 	params := map[string]string{
 		"symbol":       symbol,
@@ -104,7 +194,7 @@ func (c *RestClient) SetLeverage(leverage int, symbol string) {
 
 	resp, err := c.postRequest(params, endPoint)
 	if err != nil {
-		rlog.Fatalf("failed to set leverage: %v", err)
+		return fmt.Errorf("failed to set leverage: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -114,12 +204,92 @@ func (c *RestClient) SetLeverage(leverage int, symbol string) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		rlog.Fatalf("failed to decode response: %v", err)
+		return fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	if result.Code != 0 && result.Code != 110043 {
-		rlog.Fatalf("failed to set leverage: %s, return code: %d", result.Msg, result.Code)
+		return fmt.Errorf("failed to set leverage: %s, return code: %d", result.Msg, result.Code)
 	}
+	return nil
+}
+
+// GetRiskLimits returns the risk limit tiers of symbol.
+func (c *RestClient) GetRiskLimits(symbol string) ([]types.RiskLimit, error) {
+	resp, err := c.getRequest("category=linear&symbol="+symbol, "/v5/market/risk-limit")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get risk limit for %s: %v", symbol, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code   int    `json:"retCode"`
+		Msg    string `json:"retMsg"`
+		Result struct {
+			List []types.RiskLimit `json:"list"`
+		} `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return nil, fmt.Errorf("failed to get risk limit for %s: %s, return code: %d", symbol, result.Msg, result.Code)
+	}
+
+	return result.Result.List, nil
+}
+
+// SetTradingStop sets the take profit and stop loss of the whole position on
+// symbol. A zero price leaves that side unchanged.
+func (c *RestClient) SetTradingStop(symbol string, takeProfit, stopLoss float64) error {
+	params := map[string]string{}
+	if takeProfit != 0 {
+		params["takeProfit"] = strconv.FormatFloat(takeProfit, 'f', -1, 64)
+	}
+	if stopLoss != 0 {
+		params["stopLoss"] = strconv.FormatFloat(stopLoss, 'f', -1, 64)
+		params["slTriggerBy"] = order.TriggerBy(c.config.StopTriggerBy)
+	}
+	return c.tradingStop(symbol, params)
+}
+
+// SetTrailingStop sets Bybit's native trailing stop on the position on symbol,
+// trailing by distance once the price reaches activePrice.
+func (c *RestClient) SetTrailingStop(symbol string, distance, activePrice float64) error {
+	return c.tradingStop(symbol, map[string]string{
+		"trailingStop": strconv.FormatFloat(distance, 'f', -1, 64),
+		"activePrice":  strconv.FormatFloat(activePrice, 'f', -1, 64),
+	})
+}
+
+func (c *RestClient) tradingStop(symbol string, params map[string]string) error {
+	endPoint := "/v5/position/trading-stop"
+	params["symbol"] = symbol
+	params["category"] = "linear"
+	params["tpslMode"] = "Full"
+	params["positionIdx"] = "0"
+
+	resp, err := c.postRequest(params, endPoint)
+	if err != nil {
+		return fmt.Errorf("failed to set trading stop: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code int    `json:"retCode"`
+		Msg  string `json:"retMsg"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return &order.APIError{Code: result.Code, Msg: result.Msg}
+	}
+
+	return nil
 }
 
 func (c *RestClient) SetMarginType(marginType types.MarginType) {
@@ -186,6 +356,314 @@ func (c *RestClient) GetBalance() (float64, error) {
 	return result.Result.List[0].TotalEquity, nil
 }
 
+// GetPositions returns the open linear USDT positions, only those on symbol
+// when symbol is not empty.
+func (c *RestClient) GetPositions(symbol string) ([]types.PositionData, error) {
+	params := map[string]string{
+		"category":   "linear",
+		"settleCoin": "USDT",
+	}
+	if symbol != "" {
+		params = map[string]string{
+			"category": "linear",
+			"symbol":   symbol,
+		}
+	}
+
+	resp, err := c.getRequest(utils.EncodeMap(params), "/v5/position/list")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get positions: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code   int    `json:"retCode"`
+		Msg    string `json:"retMsg"`
+		Result struct {
+			List []struct {
+				Symbol      string  `json:"symbol"`
+				Side        string  `json:"side"`
+				Size        float64 `json:"size,string"`
+				AvgPrice    float64 `json:"avgPrice,string"`
+				CreatedTime string  `json:"createdTime"`
+			} `json:"list"`
+		} `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return nil, fmt.Errorf("failed to get positions: %s, return code: %d", result.Msg, result.Code)
+	}
+
+	var positions []types.PositionData
+	for _, p := range result.Result.List {
+		if p.Size == 0 {
+			continue
+		}
+		positions = append(positions, types.PositionData{
+			Symbol:      p.Symbol,
+			Side:        p.Side,
+			Size:        p.Size,
+			EntryPrice:  p.AvgPrice,
+			CreatedTime: p.CreatedTime,
+		})
+	}
+
+	return positions, nil
+}
+
+// GetOpenOrders returns the open orders, conditional ones included, on symbol
+// or on every linear USDT symbol when symbol is empty.
+func (c *RestClient) GetOpenOrders(symbol string) ([]types.OrderData, error) {
+	params := map[string]string{
+		"category": "linear",
+		"limit":    "50",
+	}
+	if symbol != "" {
+		params["symbol"] = symbol
+	} else {
+		params["settleCoin"] = "USDT"
+	}
+
+	var orders []types.OrderData
+	for {
+		list, cursor, err := c.getOrders("/v5/order/realtime", params)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, list...)
+		if cursor == "" || len(list) == 0 {
+			return orders, nil
+		}
+		params["cursor"] = cursor
+	}
+}
+
+// GetOrderHistory returns the latest limit orders on symbol that are no longer open.
+func (c *RestClient) GetOrderHistory(symbol string, limit int) ([]types.OrderData, error) {
+	params := map[string]string{
+		"category": "linear",
+		"symbol":   symbol,
+		"limit":    strconv.Itoa(limit),
+	}
+	orders, _, err := c.getOrders("/v5/order/history", params)
+	return orders, err
+}
+
+func (c *RestClient) getOrders(endPoint string, params map[string]string) ([]types.OrderData, string, error) {
+	resp, err := c.getRequest(utils.EncodeMap(params), endPoint)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get orders: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code   int    `json:"retCode"`
+		Msg    string `json:"retMsg"`
+		Result struct {
+			List           []types.OrderData `json:"list"`
+			NextPageCursor string            `json:"nextPageCursor"`
+		} `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return nil, "", fmt.Errorf("failed to get orders: %s, return code: %d", result.Msg, result.Code)
+	}
+
+	return result.Result.List, result.Result.NextPageCursor, nil
+}
+
+// GetClosedPnl returns the closed PnL records of symbol created between
+// startTime and endTime, all symbols when symbol is empty. A zero endTime
+// means seven days after startTime, the longest range Bybit accepts.
+func (c *RestClient) GetClosedPnl(symbol string, startTime, endTime time.Time) ([]types.ClosedPnl, error) {
+	params := map[string]string{
+		"category":  "linear",
+		"startTime": strconv.FormatInt(startTime.UnixMilli(), 10),
+		"limit":     "100",
+	}
+	if !endTime.IsZero() {
+		params["endTime"] = strconv.FormatInt(endTime.UnixMilli(), 10)
+	}
+	if symbol != "" {
+		params["symbol"] = symbol
+	}
+
+	var records []types.ClosedPnl
+	for {
+		resp, err := c.getRequest(utils.EncodeMap(params), "/v5/position/closed-pnl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get closed pnl: %v", err)
+		}
+
+		var result struct {
+			Code   int    `json:"retCode"`
+			Msg    string `json:"retMsg"`
+			Result struct {
+				List           []types.ClosedPnl `json:"list"`
+				NextPageCursor string            `json:"nextPageCursor"`
+			} `json:"result"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed to get closed pnl: %s, return code: %d", result.Msg, result.Code)
+		}
+
+		records = append(records, result.Result.List...)
+		if result.Result.NextPageCursor == "" || len(result.Result.List) == 0 {
+			return records, nil
+		}
+		params["cursor"] = result.Result.NextPageCursor
+	}
+}
+
+// GetExecutions returns the executions of symbol since startTime, trades and
+// funding settlements alike.
+func (c *RestClient) GetExecutions(symbol string, startTime time.Time) ([]types.Execution, error) {
+	params := map[string]string{
+		"category":  "linear",
+		"symbol":    symbol,
+		"startTime": strconv.FormatInt(startTime.UnixMilli(), 10),
+		"limit":     "100",
+	}
+
+	var executions []types.Execution
+	for {
+		resp, err := c.getRequest(utils.EncodeMap(params), "/v5/execution/list")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get executions: %v", err)
+		}
+
+		var result struct {
+			Code   int    `json:"retCode"`
+			Msg    string `json:"retMsg"`
+			Result struct {
+				List           []types.Execution `json:"list"`
+				NextPageCursor string            `json:"nextPageCursor"`
+			} `json:"result"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed to get executions: %s, return code: %d", result.Msg, result.Code)
+		}
+
+		executions = append(executions, result.Result.List...)
+		if result.Result.NextPageCursor == "" || len(result.Result.List) == 0 {
+			return executions, nil
+		}
+		params["cursor"] = result.Result.NextPageCursor
+	}
+}
+
+// GetSettlements returns the funding settlements of symbol booked in the
+// transaction log between startTime and endTime. The log can't be filtered by
+// symbol and every position of the account settles at the same funding time,
+// so all pages are read.
+func (c *RestClient) GetSettlements(symbol string, startTime, endTime time.Time) ([]types.Transaction, error) {
+	params := map[string]string{
+		"accountType": "UNIFIED",
+		"category":    "linear",
+		"currency":    "USDT",
+		"type":        "SETTLEMENT",
+		"startTime":   strconv.FormatInt(startTime.UnixMilli(), 10),
+		"endTime":     strconv.FormatInt(endTime.UnixMilli(), 10),
+		"limit":       "50",
+	}
+
+	var settlements []types.Transaction
+	for {
+		resp, err := c.getRequest(utils.EncodeMap(params), "/v5/account/transaction-log")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transaction log: %v", err)
+		}
+
+		var result struct {
+			Code   int    `json:"retCode"`
+			Msg    string `json:"retMsg"`
+			Result struct {
+				List           []types.Transaction `json:"list"`
+				NextPageCursor string              `json:"nextPageCursor"`
+			} `json:"result"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed to get transaction log: %s, return code: %d", result.Msg, result.Code)
+		}
+
+		for _, t := range result.Result.List {
+			if t.Symbol == symbol {
+				settlements = append(settlements, t)
+			}
+		}
+		if result.Result.NextPageCursor == "" || len(result.Result.List) == 0 {
+			return settlements, nil
+		}
+		params["cursor"] = result.Result.NextPageCursor
+	}
+}
+
+// GetFeeRate returns the account's taker and maker fee rates on symbol.
+func (c *RestClient) GetFeeRate(symbol string) (types.FeeRate, error) {
+	resp, err := c.getRequest("category=linear&symbol="+symbol, "/v5/account/fee-rate")
+	if err != nil {
+		return types.FeeRate{}, fmt.Errorf("failed to get fee rate for %s: %v", symbol, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code   int    `json:"retCode"`
+		Msg    string `json:"retMsg"`
+		Result struct {
+			List []struct {
+				TakerFeeRate float64 `json:"takerFeeRate,string"`
+				MakerFeeRate float64 `json:"makerFeeRate,string"`
+			} `json:"list"`
+		} `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return types.FeeRate{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return types.FeeRate{}, fmt.Errorf("failed to get fee rate for %s: %s, return code: %d", symbol, result.Msg, result.Code)
+	}
+
+	if len(result.Result.List) == 0 {
+		return types.FeeRate{}, fmt.Errorf("no fee rate found for %s", symbol)
+	}
+
+	return types.FeeRate{
+		Taker: result.Result.List[0].TakerFeeRate,
+		Maker: result.Result.List[0].MakerFeeRate,
+	}, nil
+}
+
 func (c *RestClient) GetAllSymbols() ([]types.Exchange, error) {
 	resp, err := c.getRequest("category=linear", "/v5/market/instruments-info")
 	if err != nil {
@@ -214,6 +692,11 @@ func (c *RestClient) GetAllSymbols() ([]types.Exchange, error) {
 				MinQty:       s.LotSizeFilter.MinOrderQty,
 				MaxQty:       s.LotSizeFilter.MaxOrderQty,
 				MaxPrice:     s.PriceFilter.MaxPrice,
+				QtyStep:      s.LotSizeFilter.QtyStep,
+				TickSize:     s.PriceFilter.TickSize,
+				MaxLeverage:  s.LeverageFilter.MaxLeverage,
+				MaxMktQty:    s.LotSizeFilter.MaxMktOrderQty,
+				MinNotional:  s.LotSizeFilter.MinNotionalValue,
 			}
 
 			symbols = append(symbols, symbol)
@@ -253,6 +736,49 @@ func (c *RestClient) GetLatestPrice(symbol string) float64 {
 	return result.Result.List[0].LastPrice
 }
 
+// GetOrderbook returns a snapshot of the order book of symbol, limit levels deep.
+func (c *RestClient) GetOrderbook(symbol string, limit int) (types.Orderbook, error) {
+	params := "category=linear&symbol=" + symbol + "&limit=" + strconv.Itoa(limit)
+	resp, err := c.getRequest(params, "/v5/market/orderbook")
+	if err != nil {
+		return types.Orderbook{}, fmt.Errorf("failed to get orderbook for %s: %v", symbol, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code   int    `json:"retCode"`
+		Msg    string `json:"retMsg"`
+		Result struct {
+			Bids [][2]string `json:"b"`
+			Asks [][2]string `json:"a"`
+		} `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return types.Orderbook{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Code != 0 {
+		return types.Orderbook{}, fmt.Errorf("failed to get orderbook for %s: %s, return code: %d", symbol, result.Msg, result.Code)
+	}
+
+	return types.Orderbook{
+		Symbol: symbol,
+		Bids:   parseLevels(result.Result.Bids),
+		Asks:   parseLevels(result.Result.Asks),
+	}, nil
+}
+
+func parseLevels(raw [][2]string) []types.OrderbookLevel {
+	levels := make([]types.OrderbookLevel, 0, len(raw))
+	for _, level := range raw {
+		price, _ := strconv.ParseFloat(level[0], 64)
+		size, _ := strconv.ParseFloat(level[1], 64)
+		levels = append(levels, types.OrderbookLevel{Price: price, Size: size})
+	}
+	return levels
+}
+
 func (c *RestClient) GetPremiumIndex(symbol string) (types.PremiumIndex, error) {
 	url := fmt.Sprintf("%s/fapi/v1/premiumIndex?symbol=%s", binanceBaseURL, symbol)
 
@@ -0,0 +1,202 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/store"
+)
+
+var rlog = log.New(os.Stdout, "[__RISK] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
+
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	rlog.SetOutput(w)
+}
+
+const riskKey = "state"
+
+// State is what the risk manager remembers across restarts.
+type State struct {
+	// Day is the UTC day DailyPnL is counted for, as 2006-01-02
+	Day            string  `json:"day"`
+	DayStartEquity float64 `json:"day_start_equity"`
+	DailyPnL       float64 `json:"daily_pnl"`
+	PeakEquity     float64 `json:"peak_equity"`
+	// Equity is the last equity read, the start of the next day
+	Equity      float64   `json:"equity"`
+	PausedUntil time.Time `json:"paused_until"`
+	Halted      bool      `json:"halted"`
+	Reason      string    `json:"reason"`
+}
+
+// Manager is the circuit breaker of the bot. It tracks the realised PnL of the
+// UTC day and the drawdown of equity from its peak, and pauses new entries
+// when either goes past its limit: until the next UTC day for the daily loss,
+// until a manual reset for the drawdown.
+type Manager struct {
+	mu     sync.Mutex
+	config *config.Config
+	store  *store.Store
+	state  State
+}
+
+func NewManager(cfg *config.Config, st *store.Store) *Manager {
+	m := &Manager{
+		config: cfg,
+		store:  st,
+	}
+
+	ok, err := st.Get(store.KindRisk, riskKey, &m.state)
+	if err != nil {
+		rlog.Fatalf("failed to load risk state: %v", err)
+	}
+	if !ok {
+		m.importLegacy(cfg.RiskStateFile)
+		return m
+	}
+	rlog.Printf("risk state loaded: %+v", m.state)
+	return m
+}
+
+// importLegacy moves the risk state of versions that kept it in its own file
+// into the store, so an upgrade keeps a halt and the peak equity. The file is
+// renamed once imported.
+func (m *Manager) importLegacy(path string) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		rlog.Fatalf("failed to read legacy risk state: %v", err)
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		rlog.Fatalf("failed to decode legacy risk state %s: %v", path, err)
+	}
+	if err := m.store.Put(store.KindRisk, riskKey, m.state); err != nil {
+		rlog.Fatalf("failed to import legacy risk state: %v", err)
+	}
+	if err := os.Rename(path, path+".imported"); err != nil {
+		rlog.Printf("Warning: failed to rename %s after import: %v", path, err)
+	}
+	rlog.Printf("risk state imported from %s: %+v", path, m.state)
+}
+
+// Allow returns an error if new entries are paused.
+func (m *Manager) Allow() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollDay(time.Now())
+
+	if m.state.Halted {
+		return fmt.Errorf("trading halted until reset: %s", m.state.Reason)
+	}
+	if time.Now().Before(m.state.PausedUntil) {
+		return fmt.Errorf("trading paused until %s: %s", m.state.PausedUntil, m.state.Reason)
+	}
+	return nil
+}
+
+// OnEquity records the current equity and trips the drawdown breaker when it
+// fell max_drawdown_percent below its peak.
+func (m *Manager) OnEquity(equity float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.rollDay(now)
+
+	m.state.Equity = equity
+	if m.state.DayStartEquity == 0 {
+		m.state.DayStartEquity = equity
+		// a loss booked before the day had an equity to measure it against
+		m.checkDailyLoss(now)
+	}
+	if equity > m.state.PeakEquity {
+		m.state.PeakEquity = equity
+	}
+
+	drawdown := (m.state.PeakEquity - equity) / m.state.PeakEquity
+	rlog.Printf("equity %f, peak %f, drawdown %.2f%%, daily pnl %f", equity, m.state.PeakEquity, drawdown*100, m.state.DailyPnL)
+	if m.config.MaxDrawdown > 0 && drawdown >= m.config.MaxDrawdown && !m.state.Halted {
+		m.state.Halted = true
+		m.state.Reason = fmt.Sprintf("drawdown %.2f%% from peak equity %f reached the limit of %.2f%%", drawdown*100, m.state.PeakEquity, m.config.MaxDrawdown*100)
+		rlog.Printf("circuit breaker tripped: %s", m.state.Reason)
+	}
+	m.save()
+}
+
+// AddPnL adds realised PnL to the day and pauses new entries until the next
+// UTC day once the loss of the day reaches max_daily_loss_percent of the
+// equity the day started with.
+func (m *Manager) AddPnL(pnl float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.rollDay(now)
+
+	m.state.DailyPnL += pnl
+	rlog.Printf("realised pnl %f, daily pnl %f", pnl, m.state.DailyPnL)
+	m.checkDailyLoss(now)
+	m.save()
+}
+
+// checkDailyLoss pauses new entries until the next UTC day once the loss of
+// the day reached its limit. m.mu must be held.
+func (m *Manager) checkDailyLoss(now time.Time) {
+	limit := m.config.MaxDailyLoss * m.state.DayStartEquity
+	if limit > 0 && -m.state.DailyPnL >= limit && now.After(m.state.PausedUntil) {
+		m.state.PausedUntil = now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		m.state.Reason = fmt.Sprintf("daily loss %f reached the limit of %f", -m.state.DailyPnL, limit)
+		rlog.Printf("circuit breaker tripped: %s, paused until %s", m.state.Reason, m.state.PausedUntil)
+	}
+}
+
+// Halt pauses new entries until a manual reset.
+func (m *Manager) Halt(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Halted = true
+	m.state.Reason = reason
+	rlog.Printf("trading halted: %s", reason)
+	m.save()
+}
+
+// Reset clears a halt and restarts the drawdown from the next equity read.
+func (m *Manager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Halted = false
+	m.state.PausedUntil = time.Time{}
+	m.state.Reason = ""
+	m.state.PeakEquity = 0
+	rlog.Println("risk state reset")
+	m.save()
+}
+
+// rollDay starts a new day of PnL once the UTC date changed, from the last
+// equity read so a loss closed before the next read counts against the limit.
+// m.mu must be held.
+func (m *Manager) rollDay(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if m.state.Day == day {
+		return
+	}
+	m.state.Day = day
+	m.state.DayStartEquity = m.state.Equity
+	m.state.DailyPnL = 0
+	m.save()
+}
+
+// save persists the state. m.mu must be held.
+func (m *Manager) save() {
+	if err := m.store.Put(store.KindRisk, riskKey, m.state); err != nil {
+		rlog.Printf("failed to save risk state: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var dlog = log.New(os.Stdout, "[_STORE] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
+
+// SetLogOutput sends the log of the package to w.
+func SetLogOutput(w io.Writer) {
+	dlog.SetOutput(w)
+}
+
+// ErrLocked is returned by Open when another process has the store open for
+// writing.
+var ErrLocked = errors.New("store is open for writing in another process")
+
+// Kinds of records kept in the store.
+const (
+	KindPosition = "position"
+	KindRisk     = "risk"
+	KindFunding  = "funding"
+	KindTrade    = "trade"
+)
+
+// record is one line of the log. A record with Deleted set removes the key.
+type record struct {
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+}
+
+// Store is a key value store kept as an append-only JSON lines log. The log is
+// replayed into memory on open and compacted to one line per live key, every
+// write is appended and synced before it returns so a crash loses nothing that
+// was acknowledged.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	// lock holds an exclusive flock on the lock file of a writable store
+	lock *os.File
+	data map[string]map[string]json.RawMessage
+}
+
+// Open loads the log, compacts it and opens it for writes. Only one process
+// can have a store open for writing, Open returns ErrLocked while another one
+// has. The lock is released on Close or when the process exits.
+func Open(path string) (*Store, error) {
+	lock, err := os.OpenFile(lockFile(path), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store lock: %v", err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+		}
+		return nil, fmt.Errorf("failed to lock store: %v", err)
+	}
+
+	s := &Store{
+		path: path,
+		lock: lock,
+		data: make(map[string]map[string]json.RawMessage),
+	}
+	if err := s.replay(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open store: %v", err)
+	}
+	s.file = file
+	return s, nil
+}
+
+// lockFile is the file a writable store at path is locked through.
+func lockFile(path string) string {
+	return path + ".lock"
+}
+
+// OpenReadOnly loads the log without compacting it or opening it for writes,
+// so it can be read while a running bot appends to it. Writes to the returned
+// store fail.
+func OpenReadOnly(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: make(map[string]map[string]json.RawMessage),
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay loads the log into memory. A torn last line, left by a crash in the
+// middle of a write, is skipped.
+func (s *Store) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open store: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			dlog.Printf("Warning: skipping corrupt line %d of %s: %v", lines, s.path, err)
+			continue
+		}
+		s.apply(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read store: %v", err)
+	}
+	dlog.Printf("replayed %d records from %s", lines, s.path)
+	return nil
+}
+
+func (s *Store) apply(rec record) {
+	if rec.Deleted {
+		delete(s.data[rec.Kind], rec.Key)
+		return
+	}
+	if s.data[rec.Kind] == nil {
+		s.data[rec.Kind] = make(map[string]json.RawMessage)
+	}
+	s.data[rec.Kind][rec.Key] = rec.Value
+}
+
+// compact rewrites the log with the live keys only, through a temporary file
+// so a crash leaves either the old or the new log.
+func (s *Store) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to compact store: %v", err)
+	}
+	w := bufio.NewWriter(file)
+	now := time.Now()
+	for kind, values := range s.data {
+		for key, value := range values {
+			line, _ := json.Marshal(record{Time: now, Kind: kind, Key: key, Value: value})
+			w.Write(append(line, '\n'))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact store: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact store: %v", err)
+	}
+	file.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to compact store: %v", err)
+	}
+	return nil
+}
+
+// Put stores v under kind and key.
+func (s *Store) Put(kind, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s: %v", kind, key, err)
+	}
+	return s.append(record{Time: time.Now(), Kind: kind, Key: key, Value: value})
+}
+
+// Delete removes key from kind.
+func (s *Store) Delete(kind, key string) error {
+	s.mu.Lock()
+	_, ok := s.data[kind][key]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.append(record{Time: time.Now(), Kind: kind, Key: key, Deleted: true})
+}
+
+func (s *Store) append(rec record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("store %s is read-only", s.path)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write store: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store: %v", err)
+	}
+	s.apply(rec)
+	return nil
+}
+
+// Get decodes the value under kind and key into v and reports whether it exists.
+func (s *Store) Get(kind, key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	value, ok := s.data[kind][key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return true, fmt.Errorf("failed to decode %s %s: %v", kind, key, err)
+	}
+	return true, nil
+}
+
+// List returns the raw values of every key of kind.
+func (s *Store) List(kind string) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]json.RawMessage, len(s.data[kind]))
+	for key, value := range s.data[kind] {
+		values[key] = value
+	}
+	return values
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	// closing the lock file releases the flock
+	s.lock.Close()
+	s.lock = nil
+	return err
+}
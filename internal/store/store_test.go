@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func get(t *testing.T, s *Store, kind, key string) (string, bool) {
+	t.Helper()
+	var v string
+	ok, err := s.Get(kind, key, &v)
+	if err != nil {
+		t.Fatalf("get %s %s: %v", kind, key, err)
+	}
+	return v, ok
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	defer file.Close()
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func TestTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	s := open(t, path)
+	if err := s.Put(KindTrade, "a", "first"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// a crash in the middle of a write
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"time":"2024-01-01T00:00:00Z","kind":"trade","key":"b","val`)
+	file.Close()
+
+	s = open(t, path)
+	if v, ok := get(t, s, KindTrade, "a"); !ok || v != "first" {
+		t.Fatalf("a = %q, %v, want first", v, ok)
+	}
+	if _, ok := get(t, s, KindTrade, "b"); ok {
+		t.Fatal("torn record b was applied")
+	}
+
+	// the next write must not be glued to the torn line
+	if err := s.Put(KindTrade, "c", "third"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s = open(t, path)
+	if v, ok := get(t, s, KindTrade, "c"); !ok || v != "third" {
+		t.Fatalf("c = %q, %v, want third", v, ok)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	s := open(t, path)
+	s.Put(KindPosition, "BTCUSDT", "long")
+	s.Put(KindPosition, "ETHUSDT", "short")
+	if err := s.Delete(KindPosition, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := get(t, s, KindPosition, "BTCUSDT"); ok {
+		t.Fatal("BTCUSDT still there after delete")
+	}
+	s.Close()
+
+	s = open(t, path)
+	if _, ok := get(t, s, KindPosition, "BTCUSDT"); ok {
+		t.Fatal("BTCUSDT back after re-open")
+	}
+	if list := s.List(KindPosition); len(list) != 1 {
+		t.Fatalf("%d positions, want 1", len(list))
+	}
+}
+
+func TestCompactAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	s := open(t, path)
+	for _, v := range []string{"1", "2", "3"} {
+		s.Put(KindRisk, "state", v)
+	}
+	s.Put(KindFunding, "t1", "x")
+	s.Put(KindFunding, "t2", "y")
+	s.Delete(KindFunding, "t2")
+	s.Close()
+	if n := lines(t, path); n != 6 {
+		t.Fatalf("%d lines before compaction, want 6", n)
+	}
+
+	s = open(t, path)
+	s.Close()
+	if n := lines(t, path); n != 2 {
+		t.Fatalf("%d lines after compaction, want 2", n)
+	}
+
+	s = open(t, path)
+	if v, ok := get(t, s, KindRisk, "state"); !ok || v != "3" {
+		t.Fatalf("state = %q, %v, want 3", v, ok)
+	}
+	if v, ok := get(t, s, KindFunding, "t1"); !ok || v != "x" {
+		t.Fatalf("t1 = %q, %v, want x", v, ok)
+	}
+	if _, ok := get(t, s, KindFunding, "t2"); ok {
+		t.Fatal("deleted t2 is back")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	s := open(t, path)
+	if _, err := Open(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second open: %v, want ErrLocked", err)
+	}
+	if _, err := OpenReadOnly(path); err != nil {
+		t.Fatalf("read-only open while locked: %v", err)
+	}
+	s.Close()
+	open(t, path)
+}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type TradeSide string
 
 const (
@@ -13,6 +18,7 @@ type WSRequest struct {
 }
 
 type WSTradeRequest struct {
+	ReqId  string        `json:"reqId"`
 	Op     string        `json:"op"`
 	Header interface{}   `json:"header"`
 	Args   []interface{} `json:"args"`
@@ -24,12 +30,21 @@ type WSResponse struct {
 	Error  interface{} `json:"error"`
 }
 
+type FeeRate struct {
+	Taker float64
+	Maker float64
+}
+
 type LastTrade struct {
-	Quantity float64
-	MinQty   float64
-	MinPrice float64
-	StopSide TradeSide
-	Symbol   string
+	OrderLinkIds  []string
+	ExpectedPrice float64
+	Quantity      float64
+	MinQty        float64
+	QtyStep       float64
+	TickSize      float64
+	StopSide      TradeSide
+	Symbol        string
+	Fees          FeeRate
 }
 
 type NextTrade struct {
@@ -53,6 +68,11 @@ type Exchange struct {
 	MinQty       float64
 	MaxQty       float64
 	MaxPrice     float64
+	QtyStep      float64
+	TickSize     float64
+	MaxLeverage  float64
+	MaxMktQty    float64
+	MinNotional  float64
 }
 
 type ExchangeResponse struct {
@@ -65,12 +85,39 @@ type ExchangeResponse struct {
 		TickSize float64 `json:"tickSize,string"`
 	}
 	LotSizeFilter struct {
-		MaxOrderQty float64 `json:"maxOrderQty,string"`
-		MinOrderQty float64 `json:"minOrderQty,string"`
-		QtyStep     float64 `json:"qtyStep,string"`
+		MaxOrderQty      float64 `json:"maxOrderQty,string"`
+		MaxMktOrderQty   float64 `json:"maxMktOrderQty,string"`
+		MinOrderQty      float64 `json:"minOrderQty,string"`
+		QtyStep          float64 `json:"qtyStep,string"`
+		MinNotionalValue float64 `json:"minNotionalValue,string"`
+	}
+	LeverageFilter struct {
+		MinLeverage  float64 `json:"minLeverage,string"`
+		MaxLeverage  float64 `json:"maxLeverage,string"`
+		LeverageStep float64 `json:"leverageStep,string"`
 	}
 }
 
+// RiskLimit is one risk limit tier of a symbol, positions up to RiskLimitValue
+// of notional can use at most MaxLeverage.
+type RiskLimit struct {
+	Id             int     `json:"id"`
+	RiskLimitValue float64 `json:"riskLimitValue,string"`
+	MaxLeverage    float64 `json:"maxLeverage,string"`
+	IsLowestRisk   int     `json:"isLowestRisk"`
+}
+
+type OrderbookLevel struct {
+	Price float64
+	Size  float64
+}
+
+type Orderbook struct {
+	Symbol string
+	Bids   []OrderbookLevel
+	Asks   []OrderbookLevel
+}
+
 type PremiumIndex struct {
 	MarkPrice       float64 `json:"markPrice,string"`
 	LastFundingRate float64 `json:"lastFundingRate,string"`
@@ -78,26 +125,87 @@ type PremiumIndex struct {
 }
 
 type EventData struct {
-	Success      bool        `json:"success,omitempty"`
-	Op           string      `json:"op,omitempty"`
-	Topic        string      `json:"topic,omitempty"`
-	CreationTime int64       `json:"creationTime,omitempty"`
-	Data         []OrderData `json:"data,omitempty"`
+	Success      bool            `json:"success,omitempty"`
+	Op           string          `json:"op,omitempty"`
+	Topic        string          `json:"topic,omitempty"`
+	CreationTime int64           `json:"creationTime,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
 }
 
 type OrderData struct {
-	AvgPrice    string  `json:"avgPrice"`
-	Qty         float64 `json:"qty,string"`
-	OrderType   string  `json:"orderType"`
-	OrderStatus string  `json:"orderStatus"`
+	Symbol        string  `json:"symbol"`
+	OrderId       string  `json:"orderId"`
+	OrderLinkId   string  `json:"orderLinkId"`
+	Side          string  `json:"side"`
+	Price         string  `json:"price"`
+	AvgPrice      string  `json:"avgPrice"`
+	TriggerPrice  string  `json:"triggerPrice"`
+	Qty           float64 `json:"qty,string"`
+	CumExecQty    float64 `json:"cumExecQty,string"`
+	OrderType     string  `json:"orderType"`
+	OrderStatus   string  `json:"orderStatus"`
+	StopOrderType string  `json:"stopOrderType"`
+	ReduceOnly    bool    `json:"reduceOnly"`
+	CreatedTime   string  `json:"createdTime"`
+}
+
+type PositionData struct {
+	Symbol      string  `json:"symbol"`
+	Side        string  `json:"side"`
+	Size        float64 `json:"size,string"`
+	EntryPrice  float64 `json:"entryPrice,string"`
+	CreatedTime string  `json:"createdTime"`
+}
+
+type ClosedPnl struct {
+	Symbol        string  `json:"symbol"`
+	OrderId       string  `json:"orderId"`
+	Side          string  `json:"side"`
+	Qty           float64 `json:"qty,string"`
+	AvgEntryPrice float64 `json:"avgEntryPrice,string"`
+	AvgExitPrice  float64 `json:"avgExitPrice,string"`
+	ClosedPnl     float64 `json:"closedPnl,string"`
+	CreatedTime   int64   `json:"createdTime,string"`
+}
+
+type Execution struct {
+	Symbol      string  `json:"symbol"`
+	OrderId     string  `json:"orderId"`
+	OrderLinkId string  `json:"orderLinkId"`
+	Side        string  `json:"side"`
+	ExecPrice   float64 `json:"execPrice,string"`
+	ExecQty     float64 `json:"execQty,string"`
+	ExecFee     float64 `json:"execFee,string"`
+	FeeRate     float64 `json:"feeRate,string"`
+	ExecType    string  `json:"execType"`
+	ExecTime    int64   `json:"execTime,string"`
+}
+
+type Transaction struct {
+	Symbol          string  `json:"symbol"`
+	Type            string  `json:"type"`
+	Side            string  `json:"side"`
+	Size            float64 `json:"size,string"`
+	Funding         float64 `json:"funding,string"`
+	FeeRate         float64 `json:"feeRate,string"`
+	TransactionTime int64   `json:"transactionTime,string"`
 }
 
 type TradeEvent struct {
-	Code   int         `json:"retCode"`
-	Msg    string      `json:"retMsg"`
-	Op     string      `json:"op"`
-	Data   interface{} `json:"data"`
-	Header interface{} `json:"header"`
+	ReqId      string          `json:"reqId"`
+	Code       int             `json:"retCode"`
+	Msg        string          `json:"retMsg"`
+	Op         string          `json:"op"`
+	Data       json.RawMessage `json:"data"`
+	RetExtInfo json.RawMessage `json:"retExtInfo"`
+	Header     interface{}     `json:"header"`
+}
+
+type Ticker struct {
+	Symbol    string
+	MarkPrice float64
+	LastPrice float64
+	Time      time.Time
 }
 
 type MarginType string
@@ -107,3 +215,84 @@ const (
 	MarginTypeRegular   MarginType = "REGULAR"
 	MarginTypePortfolio MarginType = "PORTFOLIO"
 )
+
+type TPSLMode string
+
+const (
+	TPSLModeFill        TPSLMode = "FILL"
+	TPSLModeOrder       TPSLMode = "ORDER"
+	TPSLModeTradingStop TPSLMode = "TRADING_STOP"
+)
+
+type TrailingMode string
+
+const (
+	TrailingModeNative TrailingMode = "NATIVE"
+	TrailingModeClient TrailingMode = "CLIENT"
+)
+
+type PriceSource string
+
+const (
+	PriceSourceMark  PriceSource = "MARK"
+	PriceSourceLast  PriceSource = "LAST"
+	PriceSourceIndex PriceSource = "INDEX"
+)
+
+type StopOrderType string
+
+const (
+	StopOrderTypeMarket StopOrderType = "MARKET"
+	StopOrderTypeLimit  StopOrderType = "LIMIT"
+)
+
+// TakeProfitStep is one rung of the take profit ladder, Ratio is the distance
+// from the entry price and QtyRatio the share of the position it closes.
+type TakeProfitStep struct {
+	Ratio    float64 `json:"percent"`
+	QtyRatio float64 `json:"qty_percent"`
+}
+
+type ExitOrderType string
+
+const (
+	ExitOrderTypeMarket   ExitOrderType = "MARKET"
+	ExitOrderTypeIOCLimit ExitOrderType = "IOC_LIMIT"
+)
+
+type EntryOrderType string
+
+const (
+	EntryOrderTypeMarket   EntryOrderType = "MARKET"
+	EntryOrderTypeIOCLimit EntryOrderType = "IOC_LIMIT"
+)
+
+type LeverageAction string
+
+const (
+	LeverageActionClamp LeverageAction = "CLAMP"
+	LeverageActionSkip  LeverageAction = "SKIP"
+)
+
+type SizingMode string
+
+const (
+	SizingModeFixed         SizingMode = "FIXED"
+	SizingModeEquityPercent SizingMode = "EQUITY_PERCENT"
+	SizingModeRisk          SizingMode = "RISK"
+)
+
+type ReconcilePolicy string
+
+const (
+	ReconcilePolicyAdopt ReconcilePolicy = "ADOPT"
+	ReconcilePolicyClose ReconcilePolicy = "CLOSE"
+	ReconcilePolicyAlert ReconcilePolicy = "ALERT"
+)
+
+type ShutdownPolicy string
+
+const (
+	ShutdownPolicyLeave   ShutdownPolicy = "LEAVE"
+	ShutdownPolicyFlatten ShutdownPolicy = "FLATTEN"
+)
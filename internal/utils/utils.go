@@ -13,6 +13,23 @@ func Truncate(num float64, minQty float64) float64 {
 	return math.Trunc(num/minQty) * minQty
 }
 
+// Split splits quantity into chunks of at most maxQty, dropping a remainder
+// smaller than minQty.
+func Split(quantity, maxQty, minQty, qtyStep float64) []float64 {
+	if maxQty <= 0 || quantity <= maxQty {
+		return []float64{quantity}
+	}
+	var chunks []float64
+	for quantity >= maxQty {
+		chunks = append(chunks, maxQty)
+		quantity = RoundStep(quantity-maxQty, qtyStep)
+	}
+	if quantity > 0 && quantity >= minQty {
+		chunks = append(chunks, quantity)
+	}
+	return chunks
+}
+
 // FormatFloat formats a float number with the specified precision
 func FormatFloat(num float64, precision int) string {
 	formatString := fmt.Sprintf("%%.%df", precision)
@@ -60,3 +77,11 @@ func Ticker(offset time.Duration, interval time.Duration, stopTime time.Time) {
 		}
 	}
 }
+
+// RoundStep rounds num to the nearest multiple of step
+func RoundStep(num float64, step float64) float64 {
+	if step == 0 {
+		return num
+	}
+	return math.Round(num/step) * step
+}
@@ -0,0 +1,376 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/constant"
+	"bybit-bot/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+var plog = log.New(os.Stdout, "[PUBLIC] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
+
+// PublicClient delivers real-time ticker updates of the public linear stream
+// to subscribers.
+type PublicClient struct {
+	conn         *websocket.Conn
+	config       *config.Config
+	done         chan struct{}
+	pongDone     *chan struct{}
+	lastConnTime time.Time
+
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	tickers     map[string]*types.Ticker
+	subscribers map[string][]chan types.Ticker
+}
+
+func NewPublicWebsocketConn(publicClient *PublicClient, cfg *config.Config) (*websocket.Conn, error) {
+	dialer := websocket.DefaultDialer
+
+	dialer.WriteBufferSize = 0
+	dialer.ReadBufferSize = 0
+
+	baseURL := constant.WS_PUBLIC_URL
+	if cfg.TestMode {
+		baseURL = constant.TEST_WS_PUBLIC_URL
+	}
+
+	conn, _, err := dialer.Dial(baseURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("websocket dial error: %v", err)
+	}
+
+	pongChan := make(chan struct{})
+	publicClient.writeMu.Lock()
+	publicClient.stopPing()
+	publicClient.pongDone = &pongChan
+	publicClient.writeMu.Unlock()
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pongChan:
+				return
+			case <-ticker.C:
+				publicClient.writeMu.Lock()
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"op":"ping"}`))
+				publicClient.writeMu.Unlock()
+			}
+		}
+	}()
+	return conn, nil
+}
+
+func NewPublicClient(cfg *config.Config) *PublicClient {
+	client := &PublicClient{
+		config:       cfg,
+		done:         make(chan struct{}),
+		lastConnTime: time.Now(),
+		tickers:      make(map[string]*types.Ticker),
+		subscribers:  make(map[string][]chan types.Ticker),
+	}
+
+	conn, err := NewPublicWebsocketConn(client, cfg)
+	if err != nil {
+		// no tickers until the websocket is up, callers fall back to REST prices
+		plog.Printf("public client(websocket) unavailable, reconnecting in the background: %v", err)
+		go client.Reconnect()
+		return client
+	}
+	client.conn = conn
+
+	plog.Println("public client(websocket) initialized")
+
+	go client.messageHandler()
+
+	return client
+}
+
+// Subscribe returns a channel receiving every ticker update of symbol and a
+// func to unsubscribe. A slow subscriber only misses intermediate updates,
+// the channel always holds the latest one.
+func (c *PublicClient) Subscribe(symbol string) (<-chan types.Ticker, func()) {
+	ch := make(chan types.Ticker, 1)
+
+	c.mu.Lock()
+	first := len(c.subscribers[symbol]) == 0
+	c.subscribers[symbol] = append(c.subscribers[symbol], ch)
+	if t, ok := c.tickers[symbol]; ok {
+		ch <- *t
+	}
+	c.mu.Unlock()
+
+	if first {
+		c.subscribe(symbol)
+	}
+
+	return ch, func() { c.unsubscribe(symbol, ch) }
+}
+
+// Latest returns the latest ticker of a subscribed symbol.
+func (c *PublicClient) Latest(symbol string) (types.Ticker, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tickers[symbol]
+	if !ok {
+		return types.Ticker{}, false
+	}
+	return *t, true
+}
+
+func (c *PublicClient) unsubscribe(symbol string, ch chan types.Ticker) {
+	c.mu.Lock()
+	subs := c.subscribers[symbol]
+	for i, sub := range subs {
+		if sub == ch {
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	c.subscribers[symbol] = subs
+	last := len(subs) == 0
+	if last {
+		delete(c.subscribers, symbol)
+		delete(c.tickers, symbol)
+	}
+	c.mu.Unlock()
+
+	if last {
+		c.send("unsubscribe", symbol)
+	}
+}
+
+func (c *PublicClient) subscribe(symbol string) {
+	c.send("subscribe", symbol)
+}
+
+func (c *PublicClient) send(op string, symbols ...string) {
+	args := make([]interface{}, 0, len(symbols)*2)
+	for _, symbol := range symbols {
+		args = append(args, "tickers."+symbol, "publicTrade."+symbol)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		// subscribed again once connected
+		return
+	}
+	if err := c.conn.WriteJSON(types.WSRequest{Op: op, Args: args}); err != nil {
+		plog.Printf("failed to %s %v: %v", op, symbols, err)
+	}
+}
+
+func (c *PublicClient) messageHandler() {
+	defer func() {
+		plog.Println("Message handler stopped")
+	}()
+	plog.Println("Message handler started")
+
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+			_, message, err := c.conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-c.done:
+					// closed on purpose
+					return
+				default:
+				}
+				c.Reconnect()
+				plog.Printf("websocket read message error, reconnecting: %v", err)
+				return
+			}
+
+			var event types.EventData
+			if err := json.Unmarshal(message, &event); err != nil {
+				plog.Printf("unmarshal error: %v", err)
+				plog.Printf("Raw message: %s", string(message))
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(event.Topic, "tickers."):
+				c.onTicker(event)
+			case strings.HasPrefix(event.Topic, "publicTrade."):
+				c.onTrade(event)
+			case event.Op == "pong":
+				continue
+			case event.Op == "subscribe" || event.Op == "unsubscribe":
+				if !event.Success {
+					plog.Printf("%s failed: %s", event.Op, string(message))
+				}
+			default:
+				plog.Printf("event: %s", string(message))
+			}
+		}
+	}
+}
+
+// onTicker merges a ticker snapshot or delta into the latest ticker. Deltas
+// only carry the fields that changed.
+func (c *PublicClient) onTicker(event types.EventData) {
+	var data struct {
+		Symbol    string `json:"symbol"`
+		LastPrice string `json:"lastPrice"`
+		MarkPrice string `json:"markPrice"`
+	}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		plog.Printf("failed to decode ticker: %v", err)
+		return
+	}
+
+	c.update(data.Symbol, func(t *types.Ticker) {
+		if data.LastPrice != "" {
+			t.LastPrice, _ = strconv.ParseFloat(data.LastPrice, 64)
+		}
+		if data.MarkPrice != "" {
+			t.MarkPrice, _ = strconv.ParseFloat(data.MarkPrice, 64)
+		}
+	})
+}
+
+func (c *PublicClient) onTrade(event types.EventData) {
+	var trades []struct {
+		Symbol string `json:"s"`
+		Price  string `json:"p"`
+	}
+	if err := json.Unmarshal(event.Data, &trades); err != nil {
+		plog.Printf("failed to decode public trade: %v", err)
+		return
+	}
+	if len(trades) == 0 {
+		return
+	}
+
+	last := trades[len(trades)-1]
+	c.update(last.Symbol, func(t *types.Ticker) {
+		t.LastPrice, _ = strconv.ParseFloat(last.Price, 64)
+	})
+}
+
+func (c *PublicClient) update(symbol string, apply func(t *types.Ticker)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs, ok := c.subscribers[symbol]
+	if !ok {
+		return
+	}
+	t, ok := c.tickers[symbol]
+	if !ok {
+		t = &types.Ticker{Symbol: symbol}
+		c.tickers[symbol] = t
+	}
+	apply(t)
+	t.Time = time.Now()
+
+	for _, ch := range subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- *t
+	}
+}
+
+func (c *PublicClient) Close() {
+	close(c.done)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.stopPing()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// stopPing stops the pings of the current connection. c.writeMu must be held.
+func (c *PublicClient) stopPing() {
+	if c.pongDone == nil {
+		return
+	}
+	select {
+	case <-*c.pongDone:
+	default:
+		close(*c.pongDone)
+	}
+}
+
+// Reconnect dials a new connection, swaps it in under writeMu so send never
+// sees a torn one, and subscribes the symbols that have subscribers again. A
+// failed dial is retried with backoff until the client is closed. The tickers
+// are dropped meanwhile, Latest reports no price rather than a stale one.
+func (c *PublicClient) Reconnect() {
+	c.writeMu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.writeMu.Unlock()
+	c.mu.Lock()
+	clear(c.tickers)
+	c.mu.Unlock()
+
+	conn, err := c.redial()
+	if err != nil {
+		return
+	}
+	c.writeMu.Lock()
+	c.conn = conn
+	c.writeMu.Unlock()
+	c.lastConnTime = time.Now()
+
+	c.mu.Lock()
+	symbols := make([]string, 0, len(c.subscribers))
+	for symbol := range c.subscribers {
+		symbols = append(symbols, symbol)
+	}
+	c.mu.Unlock()
+	if len(symbols) > 0 {
+		c.send("subscribe", symbols...)
+	}
+
+	go c.messageHandler()
+	plog.Println("Public client reconnected")
+}
+
+// redial dials until it succeeds, backing off up to a minute between
+// attempts. It returns an error once the client is closed.
+func (c *PublicClient) redial() (*websocket.Conn, error) {
+	backoff := time.Second
+	for {
+		conn, err := NewPublicWebsocketConn(c, c.config)
+		if err == nil {
+			select {
+			case <-c.done:
+				c.writeMu.Lock()
+				c.stopPing()
+				c.writeMu.Unlock()
+				conn.Close()
+				return nil, errClosed
+			default:
+			}
+			return conn, nil
+		}
+		plog.Printf("failed to reconnect, retrying in %s: %v", backoff, err)
+		select {
+		case <-c.done:
+			return nil, errClosed
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
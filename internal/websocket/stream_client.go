@@ -5,14 +5,12 @@ import (
 	"encoding/json"
 	"log"
 	"os"
-	"strconv"
 	"time"
 
 	"bybit-bot/config"
 	"bybit-bot/internal/constant"
-	"bybit-bot/internal/rest"
+	"bybit-bot/internal/position"
 	"bybit-bot/internal/types"
-	"bybit-bot/internal/utils"
 
 	"github.com/gorilla/websocket"
 )
@@ -24,8 +22,7 @@ type StreamClient struct {
 	config       *config.Config
 	done         chan struct{}
 	pongDone     *chan struct{}
-	tradeClient  *TradeClient
-	restClient   *rest.RestClient
+	positions    *position.Manager
 	lastConnTime time.Time
 }
 
@@ -74,15 +71,14 @@ func NewStreamWebsocketConn(streamClient *StreamClient, cfg *config.Config) *web
 	return conn
 }
 
-func NewStreamClient(tradeClient *TradeClient, restClient *rest.RestClient, cfg *config.Config) *StreamClient {
+func NewStreamClient(positions *position.Manager, cfg *config.Config) *StreamClient {
 
 	slog.Println("stream client(websocket) initialized, listening for order updates")
 
 	client := &StreamClient{
 		config:       cfg,
 		done:         make(chan struct{}),
-		tradeClient:  tradeClient,
-		restClient:   restClient,
+		positions:    positions,
 		lastConnTime: time.Now(),
 	}
 
@@ -96,7 +92,7 @@ func NewStreamClient(tradeClient *TradeClient, restClient *rest.RestClient, cfg
 func SubscribeOrderUpdates(conn *websocket.Conn) {
 	conn.WriteJSON(types.WSRequest{
 		Op:   "subscribe",
-		Args: []interface{}{"order.linear"},
+		Args: []interface{}{"order.linear", "position.linear"},
 	})
 }
 
@@ -106,11 +102,6 @@ func (c *StreamClient) messageHandler() {
 	}()
 	slog.Println("Message handler started")
 
-	var (
-		priceFloat                 float64
-		stopPrice, takeProfitPrice float64
-	)
-
 	for {
 		select {
 		case <-c.done:
@@ -118,6 +109,12 @@ func (c *StreamClient) messageHandler() {
 		default:
 			_, message, err := c.conn.ReadMessage()
 			if err != nil {
+				select {
+				case <-c.done:
+					// closed on purpose
+					return
+				default:
+				}
 				c.Reconnect()
 				slog.Printf("websocket read message error, reconnecting: %v", err)
 				return
@@ -126,103 +123,24 @@ func (c *StreamClient) messageHandler() {
 			var event types.EventData
 			if err := json.Unmarshal(message, &event); err == nil {
 				if event.Topic == "order.linear" {
-					slog.Printf("event: %+v", event)
-					if len(event.Data) == 0 {
+					var orders []types.OrderData
+					if err := json.Unmarshal(event.Data, &orders); err != nil {
+						slog.Printf("failed to decode order event: %v", err)
 						continue
 					}
-					order := event.Data[0]
-					slog.Printf("data: %+v", order)
-					if c.tradeClient.LastTrade == nil ||
-						order.OrderType != "Market" ||
-						order.OrderStatus != "Filled" {
-						continue
+					for _, data := range orders {
+						slog.Printf("order: %+v", data)
+						c.positions.OnOrderUpdate(data)
 					}
-					lastTrade := c.tradeClient.LastTrade
-					if lastTrade.Quantity != order.Qty {
-						slog.Printf("tradeData.Quantity not equal to lastTrade.Quantity: %v != %v", order.Qty, lastTrade.Quantity)
+				} else if event.Topic == "position.linear" {
+					var positions []types.PositionData
+					if err := json.Unmarshal(event.Data, &positions); err != nil {
+						slog.Printf("failed to decode position event: %v", err)
 						continue
 					}
-					priceFloat, _ = strconv.ParseFloat(order.AvgPrice, 64)
-					if c.tradeClient.LastTrade.StopSide == types.TradeSellSide {
-						stopPrice = utils.Truncate(priceFloat*(1-c.config.StopRatio), lastTrade.MinPrice)
-						takeProfitPrice = utils.Truncate(priceFloat*(1+c.config.TakeProfitRatio), lastTrade.MinPrice)
-					} else {
-						stopPrice = utils.Truncate(priceFloat*(1+c.config.StopRatio), lastTrade.MinPrice)
-						takeProfitPrice = utils.Truncate(priceFloat*(1-c.config.TakeProfitRatio), lastTrade.MinPrice)
-					}
-					go func() {
-						c.tradeClient.PlaceReduceOnlyLimitOrder(
-							lastTrade.Symbol,   // symbol
-							lastTrade.StopSide, // side
-							lastTrade.Quantity, // quantity
-							takeProfitPrice,    // take profit price
-						)
-					}()
-					go func() {
-						c.tradeClient.CreateStopOrder(
-							lastTrade.Symbol,   // symbol
-							lastTrade.StopSide, // side
-							lastTrade.Quantity, // quantity
-							stopPrice,          // stop price
-						)
-					}()
-					if c.config.BreakevenEnabled {
-						go func() {
-							tick := c.config.BreakevenWindowSize
-							placeDuration := c.config.BreakevenPlaceDuration
-							var delta float64
-							if lastTrade.StopSide == types.TradeBuySide {
-								delta = priceFloat * -c.config.BreakevenPercent
-							} else {
-								delta = priceFloat * c.config.BreakevenPercent
-							}
-							delta = utils.Truncate(delta, lastTrade.MinPrice)
-							rawPrice := priceFloat
-							cost := rawPrice + delta
-							for i := 0; i < placeDuration; i++ {
-								price := c.restClient.GetLatestPrice(lastTrade.Symbol)
-								if price != -1 {
-									if lastTrade.StopSide == types.TradeBuySide {
-										if price < cost {
-											tick--
-											slog.Printf("current(%f) < cost(%f) + delta(%f), tick: %d", price, rawPrice, delta, tick)
-											if tick == 0 {
-												slog.Println("breakeven reached, creating stop order")
-												c.tradeClient.CreateStopOrder(
-													lastTrade.Symbol,   // symbol
-													lastTrade.StopSide, // side
-													lastTrade.Quantity, // quantity
-													cost,               // stop price
-												)
-												break
-											}
-										} else {
-											tick = c.config.BreakevenWindowSize
-											slog.Printf("current(%f) >= cost(%f) + delta(%f), BAD, tick reset", price, rawPrice, delta)
-										}
-									} else {
-										if price > cost {
-											tick--
-											slog.Printf("current(%f) > cost(%f) + delta(%f), tick: %d", price, rawPrice, delta, tick)
-											if tick == 0 {
-												slog.Println("breakeven reached, creating stop order")
-												c.tradeClient.CreateStopOrder(
-													lastTrade.Symbol,   // symbol
-													lastTrade.StopSide, // side
-													lastTrade.Quantity, // quantity
-													cost,               // stop price
-												)
-												break
-											}
-										} else {
-											tick = c.config.BreakevenWindowSize
-											slog.Printf("current(%f) <= cost(%f) + delta(%f), BAD, tick reset", price, rawPrice, delta)
-										}
-									}
-								}
-								time.Sleep(1 * time.Second)
-							}
-						}()
+					for _, data := range positions {
+						slog.Printf("position: %+v", data)
+						c.positions.OnPositionUpdate(data)
 					}
 				} else if event.Op == "subscribe" {
 					slog.Printf("subscribe event return: %+v", event.Success)
@@ -231,7 +149,7 @@ func (c *StreamClient) messageHandler() {
 				} else if event.Op == "pong" {
 					continue
 				} else {
-					slog.Printf("event: %+v", event)
+					slog.Printf("event: %s", string(message))
 				}
 			} else {
 				slog.Printf("error: %+v", err)
@@ -2,14 +2,19 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"bybit-bot/config"
 	"bybit-bot/internal/constant"
+	"bybit-bot/internal/order"
 	"bybit-bot/internal/types"
 	"bybit-bot/internal/utils"
 
@@ -18,17 +23,34 @@ import (
 
 var tlog = log.New(os.Stdout, "[_TRADE] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
 
+// SetLogOutput sends the log of the trade, stream and public clients to w.
+func SetLogOutput(w io.Writer) {
+	tlog.SetOutput(w)
+	slog.SetOutput(w)
+	plog.SetOutput(w)
+}
+
+const ackTimeout = 3 * time.Second
+
+// errClosed is returned by a redial once the client is closed.
+var errClosed = errors.New("websocket client closed")
+
 type TradeClient struct {
 	conn         *websocket.Conn
 	config       *config.Config
 	done         chan struct{}
 	pongDone     *chan struct{}
 	NextTrade    *types.NextTrade
-	LastTrade    *types.LastTrade
 	lastConnTime time.Time
+
+	writeMu   sync.Mutex
+	connected atomic.Bool
+	reqSeq    atomic.Uint64
+	pendingMu sync.Mutex
+	pending   map[string]chan types.TradeEvent
 }
 
-func NewTradeWebsocketConn(tradeClient *TradeClient, cfg *config.Config) *websocket.Conn {
+func NewTradeWebsocketConn(tradeClient *TradeClient, cfg *config.Config) (*websocket.Conn, error) {
 	dialer := websocket.DefaultDialer
 
 	dialer.WriteBufferSize = 0
@@ -41,35 +63,31 @@ func NewTradeWebsocketConn(tradeClient *TradeClient, cfg *config.Config) *websoc
 
 	conn, _, err := dialer.Dial(baseURL, nil)
 	if err != nil {
-		tlog.Fatalf("websocket dial error: %v", err)
-	}
-
-	Auth(conn, cfg)
-
-	if tradeClient != nil && tradeClient.pongDone != nil {
-		select {
-		case <-*tradeClient.pongDone:
-			break
-		default:
-			close(*tradeClient.pongDone)
-		}
+		return nil, fmt.Errorf("websocket dial error: %v", err)
 	}
 
 	pongChan := make(chan struct{})
+	tradeClient.writeMu.Lock()
+	Auth(conn, cfg)
+	tradeClient.stopPing()
 	tradeClient.pongDone = &pongChan
+	tradeClient.writeMu.Unlock()
+
 	go func() {
 		ticker := time.NewTicker(10 * time.Second)
 		defer ticker.Stop()
 		for {
 			select {
-			case <-*tradeClient.pongDone:
+			case <-pongChan:
 				return
 			case <-ticker.C:
+				tradeClient.writeMu.Lock()
 				conn.WriteMessage(websocket.TextMessage, []byte(`{"op":"ping"}`))
+				tradeClient.writeMu.Unlock()
 			}
 		}
 	}()
-	return conn
+	return conn, nil
 }
 
 func NewTradeClient(cfg *config.Config) *TradeClient {
@@ -78,9 +96,17 @@ func NewTradeClient(cfg *config.Config) *TradeClient {
 		config:       cfg,
 		done:         make(chan struct{}),
 		lastConnTime: time.Now(),
+		pending:      make(map[string]chan types.TradeEvent),
 	}
 
-	client.conn = NewTradeWebsocketConn(client, cfg)
+	conn, err := NewTradeWebsocketConn(client, cfg)
+	if err != nil {
+		// orders go to REST until the websocket is up
+		tlog.Printf("trade client(websocket) unavailable, reconnecting in the background: %v", err)
+		go client.Reconnect()
+		return client
+	}
+	client.conn = conn
 
 	tlog.Printf("trade client(websocket) initialized")
 
@@ -102,6 +128,15 @@ func (c *TradeClient) StartMessageHandler() {
 		default:
 			_, message, err := c.conn.ReadMessage()
 			if err != nil {
+				select {
+				case <-c.done:
+					// closed on purpose
+					c.failPending()
+					return
+				default:
+				}
+				c.connected.Store(false)
+				c.failPending()
 				c.Reconnect()
 				tlog.Printf("read message error, reconnecting: %v", err)
 				return
@@ -115,16 +150,39 @@ func (c *TradeClient) StartMessageHandler() {
 				if tradeEvent.Op == "pong" {
 					continue
 				}
-				tlog.Printf("TradeEvent: %+v", tradeEvent)
+				if tradeEvent.Op == "auth" {
+					c.connected.Store(tradeEvent.Code == 0)
+				}
+				tlog.Printf("TradeEvent: %s", string(message))
+				if tradeEvent.ReqId != "" {
+					c.resolve(tradeEvent)
+				}
 			}
 		}
 	}
 }
 
 func (c *TradeClient) Close() {
-	close(*c.pongDone)
+	c.connected.Store(false)
 	close(c.done)
-	c.conn.Close()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.stopPing()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// stopPing stops the pings of the current connection. c.writeMu must be held.
+func (c *TradeClient) stopPing() {
+	if c.pongDone == nil {
+		return
+	}
+	select {
+	case <-*c.pongDone:
+	default:
+		close(*c.pongDone)
+	}
 }
 
 func Auth(conn *websocket.Conn, config *config.Config) {
@@ -137,75 +195,175 @@ func Auth(conn *websocket.Conn, config *config.Config) {
 	})
 }
 
-func (c *TradeClient) CreateOrder(params map[string]string) {
-	tlog.Printf("place order: %v", params)
+func (c *TradeClient) Name() string {
+	return "websocket"
+}
 
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+func (c *TradeClient) Available() bool {
+	return c.connected.Load()
+}
 
-	request := types.WSTradeRequest{
-		Op:     "order.create",
-		Header: map[string]string{"X-BAPI-TIMESTAMP": timestamp},
-		Args:   []interface{}{params},
-	}
+func (c *TradeClient) CreateOrder(params map[string]string) (order.Result, error) {
+	return c.orderRequest("order.create", params)
+}
 
-	c.conn.WriteJSON(request)
+func (c *TradeClient) AmendOrder(params map[string]string) (order.Result, error) {
+	return c.orderRequest("order.amend", params)
 }
 
-func (c *TradeClient) CreateMarketOrder(symbol string, side types.TradeSide, quantity float64) {
-	params := map[string]string{
-		"symbol":    symbol,
-		"qty":       strconv.FormatFloat(quantity, 'f', -1, 64),
-		"side":      string(side),
-		"orderType": "Market",
-		"category":  "linear",
+func (c *TradeClient) CancelOrder(params map[string]string) (order.Result, error) {
+	return c.orderRequest("order.cancel", params)
+}
+
+func (c *TradeClient) orderRequest(op string, params map[string]string) (order.Result, error) {
+	event, err := c.request(op, []interface{}{params})
+	if err != nil {
+		return order.Result{}, err
 	}
 
-	c.CreateOrder(params)
+	var result order.Result
+	if err := json.Unmarshal(event.Data, &result); err != nil {
+		return order.Result{}, fmt.Errorf("failed to decode %s response: %v", op, err)
+	}
+	return result, nil
 }
 
-func (c *TradeClient) PlaceReduceOnlyLimitOrder(symbol string, side types.TradeSide, quantity, price float64) {
-	params := map[string]string{
-		"symbol":     symbol,
-		"side":       string(side),
-		"price":      strconv.FormatFloat(price, 'f', -1, 64),
-		"qty":        strconv.FormatFloat(quantity, 'f', -1, 64),
-		"orderType":  "Limit",
-		"reduceOnly": "true",
-		"category":   "linear",
-	}
+func (c *TradeClient) CreateBatch(requests []map[string]string) ([]order.BatchResult, error) {
+	return c.batchRequest("order.create-batch", requests)
+}
 
-	c.CreateOrder(params)
+func (c *TradeClient) AmendBatch(requests []map[string]string) ([]order.BatchResult, error) {
+	return c.batchRequest("order.amend-batch", requests)
 }
 
-func (c *TradeClient) CreateStopOrder(symbol string, side types.TradeSide, quantity, stopPrice float64) {
-	triggerDirection := "1"
-	if side == types.TradeSellSide {
-		triggerDirection = "2"
+func (c *TradeClient) CancelBatch(requests []map[string]string) ([]order.BatchResult, error) {
+	return c.batchRequest("order.cancel-batch", requests)
+}
+
+func (c *TradeClient) batchRequest(op string, requests []map[string]string) ([]order.BatchResult, error) {
+	event, err := c.request(op, []interface{}{order.BatchArgs(requests)})
+	if err != nil {
+		return nil, err
+	}
+	return order.DecodeBatch(event.Data, event.RetExtInfo, len(requests))
+}
+
+// request sends op over the trade websocket and waits for the matching ack.
+func (c *TradeClient) request(op string, args []interface{}) (types.TradeEvent, error) {
+	if !c.connected.Load() {
+		return types.TradeEvent{}, order.ErrUnavailable
+	}
+
+	reqId := strconv.FormatUint(c.reqSeq.Add(1), 10)
+	ack := make(chan types.TradeEvent, 1)
+	c.pendingMu.Lock()
+	c.pending[reqId] = ack
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, reqId)
+		c.pendingMu.Unlock()
+	}()
+
+	request := types.WSTradeRequest{
+		ReqId:  reqId,
+		Op:     op,
+		Header: map[string]string{"X-BAPI-TIMESTAMP": strconv.FormatInt(time.Now().UnixMilli(), 10)},
+		Args:   args,
 	}
 
-	params := map[string]string{
-		"symbol":           symbol,
-		"side":             string(side),
-		"orderType":        "Limit",
-		"qty":              strconv.FormatFloat(quantity, 'f', -1, 64),
-		"reduceOnly":       "true",
-		"triggerPrice":     strconv.FormatFloat(stopPrice, 'f', -1, 64),
-		"price":            strconv.FormatFloat(stopPrice, 'f', -1, 64),
-		"triggerDirection": triggerDirection,
-		"category":         "linear",
+	c.writeMu.Lock()
+	err := c.conn.WriteJSON(request)
+	c.writeMu.Unlock()
+	if err != nil {
+		return types.TradeEvent{}, fmt.Errorf("%w: %v", order.ErrUnavailable, err)
 	}
 
-	c.CreateOrder(params)
+	select {
+	case event, ok := <-ack:
+		if !ok {
+			return types.TradeEvent{}, fmt.Errorf("%w: connection lost while waiting for %s ack", order.ErrUnavailable, op)
+		}
+		if event.Code != 0 {
+			return event, &order.APIError{Code: event.Code, Msg: event.Msg}
+		}
+		return event, nil
+	case <-time.After(ackTimeout):
+		return types.TradeEvent{}, fmt.Errorf("%w: %s ack timeout", order.ErrUnavailable, op)
+	}
+}
+
+func (c *TradeClient) resolve(event types.TradeEvent) {
+	c.pendingMu.Lock()
+	ack, ok := c.pending[event.ReqId]
+	delete(c.pending, event.ReqId)
+	c.pendingMu.Unlock()
+	if ok {
+		ack <- event
+	}
 }
 
+// failPending releases every request still waiting for an ack on a dead connection.
+func (c *TradeClient) failPending() {
+	c.pendingMu.Lock()
+	for reqId, ack := range c.pending {
+		close(ack)
+		delete(c.pending, reqId)
+	}
+	c.pendingMu.Unlock()
+}
+
+// Reconnect dials a new connection and swaps it in under writeMu, requests in
+// flight write to either the old or the new connection, never a torn one. A
+// failed dial is retried with backoff until the client is closed, the client
+// stays unavailable meanwhile so the router sends orders to REST.
 func (c *TradeClient) Reconnect() {
-	c.conn.Close()
-	c.conn = NewTradeWebsocketConn(c, c.config)
+	c.writeMu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.writeMu.Unlock()
+
+	conn, err := c.redial()
+	if err != nil {
+		return
+	}
+	c.writeMu.Lock()
+	c.conn = conn
+	c.writeMu.Unlock()
 	c.lastConnTime = time.Now()
 	go c.StartMessageHandler()
 	tlog.Println("Trade client reconnected")
 }
 
+// redial dials until it succeeds, backing off up to a minute between
+// attempts. It returns an error once the client is closed.
+func (c *TradeClient) redial() (*websocket.Conn, error) {
+	backoff := time.Second
+	for {
+		conn, err := NewTradeWebsocketConn(c, c.config)
+		if err == nil {
+			select {
+			case <-c.done:
+				c.writeMu.Lock()
+				c.stopPing()
+				c.writeMu.Unlock()
+				conn.Close()
+				return nil, errClosed
+			default:
+			}
+			return conn, nil
+		}
+		tlog.Printf("failed to reconnect, retrying in %s: %v", backoff, err)
+		select {
+		case <-c.done:
+			return nil, errClosed
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
 func (c *TradeClient) EnsureConnection() {
 	if time.Since(c.lastConnTime) > 8*time.Hour {
 		tlog.Println("lastConnTime is greater than 8 hours, establishing new connection")
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/position"
+	"bybit-bot/internal/risk"
+)
+
+// watchKillSwitch fires the kill switch whenever the kill switch file appears
+// and removes the file once it is handled, which tells the kill command the
+// bot is done. It returns once stop is closed.
+func watchKillSwitch(path string, positions *position.Manager, breaker *risk.Manager, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		killSwitch(positions, breaker, "kill switch file "+path)
+		if err := os.Remove(path); err != nil {
+			mlog.Printf("failed to remove kill switch file: %v", err)
+		}
+	}
+}
+
+// watchResetRisk resets the risk state whenever the reset file of
+// resetRiskFile appears and removes the file once handled, which tells the
+// reset-risk command the bot is done. It polls apart from the kill switch, a
+// flatten in progress doesn't hold up a reset. It returns once stop is closed.
+func watchResetRisk(path string, positions *position.Manager, breaker *risk.Manager, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		mlog.Printf("risk state reset requested by %s", path)
+		breaker.Reset()
+		positions.Resume()
+		if err := os.Remove(path); err != nil {
+			mlog.Printf("failed to remove reset file: %v", err)
+		}
+	}
+}
+
+// resetRiskFile is the file the reset-risk command creates to have the
+// running bot reset its risk state. It sits next to the state file it resets.
+func resetRiskFile(cfg *config.Config) string {
+	return cfg.StateFile + ".reset-risk"
+}
+
+// killSwitch halts new entries, then flattens every position the bot manages
+// and cancels its orders. The halt is persisted and lasts until the risk
+// state is reset.
+func killSwitch(positions *position.Manager, breaker *risk.Manager, trigger string) {
+	mlog.Printf("kill switch fired by %s", trigger)
+	breaker.Halt("kill switch fired by " + trigger)
+	if err := positions.FlattenAll(position.ReasonKilled, time.Minute); err != nil {
+		mlog.Printf("ALERT: %v", err)
+		return
+	}
+	mlog.Println("kill switch done, flat and halted")
+}
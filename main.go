@@ -2,40 +2,206 @@ package main
 
 import (
 	"bybit-bot/config"
+	"bybit-bot/internal/journal"
+	"bybit-bot/internal/order"
+	"bybit-bot/internal/position"
 	"bybit-bot/internal/rest"
+	"bybit-bot/internal/risk"
+	"bybit-bot/internal/store"
 	"bybit-bot/internal/types"
 	"bybit-bot/internal/utils"
 	"bybit-bot/internal/websocket"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 )
 
 var mlog = log.New(os.Stdout, "[__MAIN] ", log.Ldate|log.Lmicroseconds|log.Lmsgprefix)
 
 func main() {
-	cfg := config.NewConfig("config.json")
+	configPath := flag.String("config", "config.json", "path of the config file")
+	flag.Usage = usage
+	flag.Parse()
 
+	command, args := "run", []string{}
+	if flag.NArg() > 0 {
+		command, args = flag.Arg(0), flag.Args()[1:]
+	}
+
+	if command != "run" {
+		// commands run once print their result on stdout, keep the log out of it
+		logTo(os.Stderr)
+	}
+
+	cfg := config.NewConfig(*configPath)
 	restClient := rest.NewRestClient(cfg)
+
+	switch command {
+	case "run":
+		run(cfg, restClient)
+	case "scan":
+		scan(cfg, restClient)
+	case "balance":
+		printBalance(restClient)
+	case "positions":
+		printPositions(restClient, args)
+	case "orders":
+		printOrders(restClient, args)
+	case "close-all":
+		closeAll(cfg, restClient, args)
+	case "set-leverage":
+		setLeverage(cfg, restClient, args)
+	case "kill":
+		kill(cfg, args)
+	case "reset-risk":
+		resetRisk(cfg, args)
+	case "report":
+		printReport(cfg, restClient, openJournal(cfg, restClient), args)
+	case "export":
+		exportJournal(openJournal(cfg, restClient), args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// logTo sends the log of every package to w.
+func logTo(w io.Writer) {
+	mlog.SetOutput(w)
+	config.SetLogOutput(w)
+	rest.SetLogOutput(w)
+	store.SetLogOutput(w)
+	journal.SetLogOutput(w)
+	risk.SetLogOutput(w)
+	order.SetLogOutput(w)
+	position.SetLogOutput(w)
+	websocket.SetLogOutput(w)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: bybit-bot [-config config.json] [command] [flags]
+
+commands:
+  run           trade every funding time, the default
+  scan          print the candidates and the next funding time
+  balance       print the USDT equity
+  positions     print the open positions
+  orders        print the open orders
+  close-all     cancel the open orders and close the positions at market
+  set-leverage  set the leverage of a symbol
+  kill          fire the kill switch of the running bot
+  reset-risk    clear a halt or pause of the circuit breaker
+  report        print the performance of the closed trades
+  export        export the trade journal
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+// openJournal opens the trade journal for reading, alongside a bot that may be
+// running on the same state file.
+func openJournal(cfg *config.Config, restClient *rest.RestClient) *journal.Journal {
+	st, err := store.OpenReadOnly(cfg.StateFile)
+	if err != nil {
+		mlog.Fatalf("failed to open state file: %v", err)
+	}
+	return journal.New(restClient, st)
+}
+
+// run trades the funding times until the process is stopped.
+func run(cfg *config.Config, restClient *rest.RestClient) {
+	st, err := store.Open(cfg.StateFile)
+	if err != nil {
+		mlog.Fatalf("failed to open state file: %v", err)
+	}
+	trades := journal.New(restClient, st)
+
 	tradeClient := websocket.NewTradeClient(cfg)
-	websocket.NewStreamClient(tradeClient, restClient, cfg)
+	// the trade websocket is preferred for latency, REST takes over while it is down
+	orders := order.NewRouter(cfg, tradeClient, restClient)
+	publicClient := websocket.NewPublicClient(cfg)
+	positions := position.NewManager(cfg, orders, restClient, publicClient, st)
+	streamClient := websocket.NewStreamClient(positions, cfg)
+	breaker := risk.NewManager(cfg, st)
+	positions.OnOpen(trades.Opened)
+	positions.OnClose(func(pos *position.Position) {
+		trade, err := trades.Closed(pos)
+		if err != nil {
+			mlog.Printf("Warning: %v, not counted towards the daily loss", err)
+			return
+		}
+		breaker.AddPnL(trade.RealisedPnl)
+	})
 
 	balance, err := restClient.GetBalance()
 	if err != nil {
-		mlog.Printf("Warning: failed to get balance: %v", err)
-		balance = 9999999999
+		mlog.Fatalf("refusing to trade without a balance: %v", err)
 	}
 
-	if balance < cfg.Margin {
-		mlog.Fatalf("balance is too low: %f", balance)
+	if cfg.SizingMode == types.SizingModeFixed {
+		if balance < cfg.Margin {
+			mlog.Fatalf("balance is too low: %f", balance)
+		}
+		mlog.Printf("usdt balance: %f, can trade %d times", balance, int(balance/cfg.Margin))
+	} else {
+		mlog.Printf("usdt balance: %f, sizing mode %s", balance, cfg.SizingMode)
 	}
-	mlog.Printf("usdt balance: %f, can trade %d times", balance, int(balance/cfg.Margin))
 
 	restClient.SetMarginType(cfg.MarginType)
 	mlog.Printf("set account margin type to %s", cfg.MarginType)
 
+	// pick up whatever a previous run left open before trading on top of it
+	positions.Restore()
+	if err := positions.Reconcile(); err != nil {
+		mlog.Fatalf("failed to reconcile positions and orders: %v", err)
+	}
+	// the first signal stops new entries and shuts down cleanly, a second one
+	// exits at once
+	stopping := make(chan struct{})
+
+	// the background loops stop with the bot, before the positions shut down
+	// and the clients and the state file they use are closed
+	var background sync.WaitGroup
+	goBackground := func(f func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			f()
+		}()
+	}
+	if cfg.ReconcileInterval > 0 {
+		goBackground(func() { positions.ReconcileEvery(time.Duration(cfg.ReconcileInterval)*time.Second, stopping) })
+	}
+	goBackground(func() { trades.ReconcileFundingEvery(5*time.Minute, cfg.FundingRateGap, stopping) })
+	goBackground(func() { watchKillSwitch(cfg.KillSwitchFile, positions, breaker, stopping) })
+	goBackground(func() { watchResetRisk(resetRiskFile(cfg), positions, breaker, stopping) })
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		mlog.Printf("received %s, shutting down", sig)
+		close(stopping)
+		<-signals
+		mlog.Fatalf("received a second signal, exiting without cleanup")
+	}()
+
+loop:
 	for {
+		select {
+		case <-stopping:
+			break loop
+		default:
+		}
+
 		// tradeClient.EnsureConnection()
 		// streamClient.EnsureConnection()
 
@@ -81,57 +247,61 @@ func main() {
 		if time.Until(fundingTime) > time.Minute*5 {
 			distance := time.Until(fundingTime)
 			mlog.Printf("funding time is too far away, sleep for %s, will wake up at %s", distance-5*time.Minute, fundingTime.Add(-5*time.Minute))
-			time.Sleep(distance - 5*time.Minute)
+			sleep(stopping, distance-5*time.Minute)
 			continue
 		}
 
 		if len(top5) == 0 {
 			mlog.Println("no funding rate found, sleep for 5 minutes")
-			time.Sleep(time.Minute * 5)
+			sleep(stopping, time.Minute*5)
 			continue
 		}
 
-		top := top5[0]
-
-		mlog.Printf("selected symbol: %+v", top)
-
-		restClient.SetLeverage(cfg.Leverage, top.Symbol.Symbol)
-		mlog.Printf("set leverage to %dx", cfg.Leverage)
+		if traded(st, fundingTime) {
+			mlog.Printf("funding time %s already traded, skipping", fundingTime)
+			sleep(stopping, time.Until(fundingTime)+time.Second)
+			continue
+		}
 
 		mlog.Println("sleep. will wake up at ", fundingTime.Add(-time.Minute))
-		time.Sleep(time.Until(fundingTime) - time.Minute)
+		if !sleep(stopping, time.Until(fundingTime)-time.Minute) {
+			continue
+		}
 
-		mlog.Printf("querying latest price for %s", top.Symbol.Symbol)
-		premiumIndex, err := restClient.GetPremiumIndex(top.Symbol.Symbol)
+		// size against the equity of now, so profits and losses compound
+		equity, err := restClient.GetBalance()
 		if err != nil {
-			mlog.Printf("failed to get premium index: %v", err)
+			mlog.Printf("refusing to trade without a balance: %v", err)
+			sleep(stopping, time.Until(fundingTime)+time.Second)
 			continue
 		}
-		priceFloat := premiumIndex.MarkPrice
-		mlog.Printf("latest price: %f", priceFloat)
-
-		quantity := (cfg.Margin * float64(cfg.Leverage) / priceFloat)
-
-		mlog.Printf("quantity: %v", quantity)
 
-		if quantity < top.Symbol.MinQty {
-			mlog.Fatalf("calculated order quantity is %v, smaller than min qty %v, please adjust balance", quantity, top.Symbol.MinQty)
+		breaker.OnEquity(equity)
+		if err := breaker.Allow(); err != nil {
+			mlog.Printf("skipping funding time %s: %v", fundingTime, err)
+			sleep(stopping, time.Until(fundingTime)+time.Second)
+			continue
 		}
 
-		quantity = utils.Truncate(quantity, top.Symbol.MinQty)
+		plan := selectEntry(restClient, cfg, top5, equity)
+		if plan == nil {
+			mlog.Printf("no tradable candidate for funding time %s, skipping", fundingTime)
+			sleep(stopping, time.Until(fundingTime)+time.Second)
+			continue
+		}
+		top := plan.top
+		side, stopSide := plan.side, plan.stopSide
+		priceFloat, quantity := plan.price, plan.quantity
 
-		side := types.TradeSellSide
-		stopSide := types.TradeBuySide
+		mlog.Printf("selected symbol: %+v", top)
 
-		if top.PremiumIndex.LastFundingRate > 0 {
-			side = types.TradeBuySide
-			stopSide = types.TradeSellSide
-		}
+		// keep the Bybit mark price of the symbol fresh for the entry
+		_, unsubscribe := publicClient.Subscribe(top.Symbol.Symbol)
 
 		mlog.Printf("going to place order: %s %s %s %s %s",
 			top.Symbol.Symbol,
 			side,
-			"Market",
+			cfg.EntryOrderType,
 			strconv.FormatFloat(quantity, 'f', -1, 64),
 			"",
 		)
@@ -146,19 +316,110 @@ func main() {
 		utils.Ticker(offset, time.Second, fundingTime)
 		mlog.Println("ticker done")
 
-		tradeClient.CreateMarketOrder(
-			top.Symbol.Symbol, // symbol
-			side,              // side
-			quantity,          // quantity
-		)
-		tradeClient.LastTrade = &types.LastTrade{
-			MinQty:   top.Symbol.MinQty,
-			MinPrice: top.Symbol.MinPrice,
-			Symbol:   top.Symbol.Symbol,
-			StopSide: stopSide,
-			Quantity: quantity,
-		}
-		mlog.Printf("setting LastTrade: %+v", tradeClient.LastTrade)
-		time.Sleep(time.Minute)
+		expectedPrice := priceFloat
+		if t, ok := publicClient.Latest(top.Symbol.Symbol); ok && t.MarkPrice != 0 {
+			expectedPrice = t.MarkPrice
+		}
+		unsubscribe()
+
+		var stopPrice, takeProfitPrice float64
+		if cfg.TPSLMode != types.TPSLModeFill {
+			stopPrice, takeProfitPrice = position.ExitPrices(cfg, expectedPrice, stopSide, top.Symbol.TickSize, plan.fees)
+		}
+
+		requests := make([]map[string]string, 0, len(plan.legs))
+		orderLinkIds := make([]string, 0, len(plan.legs))
+		for _, legQuantity := range plan.legs {
+			params := orders.EntryParams(top.Symbol.Symbol, side, legQuantity, expectedPrice, top.Symbol.TickSize)
+			if cfg.TPSLMode == types.TPSLModeOrder {
+				orders.AttachTPSL(params, takeProfitPrice, stopPrice)
+			}
+			params["orderLinkId"] = order.NewLinkId()
+			requests = append(requests, params)
+			orderLinkIds = append(orderLinkIds, params["orderLinkId"])
+		}
+
+		// the kill switch may have fired or a shutdown started while waiting
+		// for the funding time
+		if err := breaker.Allow(); err != nil {
+			mlog.Printf("not entering %s: %v", top.Symbol.Symbol, err)
+			continue
+		}
+		select {
+		case <-stopping:
+			mlog.Printf("not entering %s: shutting down", top.Symbol.Symbol)
+			continue
+		default:
+		}
+
+		positions.ExpectEntry(&types.LastTrade{
+			OrderLinkIds:  orderLinkIds,
+			ExpectedPrice: expectedPrice,
+			MinQty:        top.Symbol.MinQty,
+			QtyStep:       top.Symbol.QtyStep,
+			TickSize:      top.Symbol.TickSize,
+			Symbol:        top.Symbol.Symbol,
+			StopSide:      stopSide,
+			Quantity:      quantity,
+			Fees:          plan.fees,
+		})
+		markTraded(st, fundingTime, top.Symbol.Symbol, orderLinkIds)
+		trades.Plan(journal.Trade{
+			Id:               orderLinkIds[0],
+			Symbol:           top.Symbol.Symbol,
+			Side:             string(side),
+			FundingTime:      fundingTime,
+			FundingRateSeen:  top.PremiumIndex.LastFundingRate,
+			PlannedEntryTime: fundingTime.Add(offset),
+			ExpectedPrice:    expectedPrice,
+			PlannedQuantity:  quantity,
+		})
+		if !placeEntry(orders, positions, requests) {
+			continue
+		}
+
+		if cfg.TPSLMode == types.TPSLModeTradingStop {
+			setTradingStop(restClient, top.Symbol.Symbol, takeProfitPrice, stopPrice)
+		}
+		sleep(stopping, time.Minute)
+	}
+
+	mlog.Printf("shutting down with shutdown policy %s", cfg.ShutdownPolicy)
+	background.Wait()
+	if err := positions.Shutdown(cfg.ShutdownPolicy == types.ShutdownPolicyFlatten, time.Minute); err != nil {
+		mlog.Printf("ALERT: %v", err)
+	}
+	streamClient.Close()
+	publicClient.Close()
+	tradeClient.Close()
+	if err := st.Close(); err != nil {
+		mlog.Printf("failed to close state file: %v", err)
+	}
+	mlog.Println("shutdown complete")
+}
+
+// sleep waits for d and reports whether the bot is still running after it.
+func sleep(stopping <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stopping:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// setTradingStop attaches take profit and stop loss to a position that was
+// just opened, retrying while the position is not visible to the API yet.
+func setTradingStop(restClient *rest.RestClient, symbol string, takeProfit, stopLoss float64) {
+	var err error
+	for i := 0; i < 5; i++ {
+		if err = restClient.SetTradingStop(symbol, takeProfit, stopLoss); err == nil {
+			mlog.Printf("trading stop set for %s: tp %f, sl %f", symbol, takeProfit, stopLoss)
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
 	}
+	mlog.Printf("failed to set trading stop for %s: %v", symbol, err)
 }
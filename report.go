@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"bybit-bot/config"
+	"bybit-bot/internal/journal"
+	"bybit-bot/internal/report"
+	"bybit-bot/internal/rest"
+)
+
+// printReport prints the performance of the closed trades of the last days,
+// from the journal and Bybit's closed PnL, as a table or as JSON.
+func printReport(cfg *config.Config, restClient *rest.RestClient, trades *journal.Journal, args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	days := flags.Int("days", 30, "number of days to report on")
+	format := flags.String("format", "table", "output format, table or json")
+	output := flags.String("o", "", "output file, standard output by default")
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		mlog.Fatalf("format should only be one of table or json")
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -*days)
+	records, err := report.ClosedPnl(restClient, from, to)
+	if err != nil {
+		mlog.Fatalf("failed to get closed pnl: %v", err)
+	}
+	r := report.Build(trades.Trades(), records, from, to, cfg.StopRatio)
+
+	w := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			mlog.Fatalf("failed to create %s: %v", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "table" {
+		r.PrintTable(w)
+		return
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(r); err != nil {
+		mlog.Fatalf("failed to encode report: %v", err)
+	}
+}